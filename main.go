@@ -1,3 +1,5 @@
+//go:build js && wasm
+
 package main
 
 import packagebuilder "theparadance.com/quan-lang/package-builder"
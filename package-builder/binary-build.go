@@ -3,11 +3,13 @@ package packagebuilder
 import (
 	"encoding/json"
 	"flag"
+	"path/filepath"
 
 	lang "theparadance.com/quan-lang/quan-lang"
 	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
 	debuglevel "theparadance.com/quan-lang/src/debug/debug-level"
 	"theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/module"
 	systemconsole "theparadance.com/quan-lang/src/system-console"
 	"theparadance.com/quan-lang/utils"
 )
@@ -18,6 +20,12 @@ func BuildBinary() {
 	programPath := flag.String("i", ".", "The program to execute")
 	mode := string(*flag.String("mode", lang.DEBUG_MODE, "Execution mode: DEBUG or RELEASE"))
 	envs := flag.String("envs", "{}", "Environment variables in JSON format")
+	engine := flag.String("engine", lang.TREE_WALK_ENGINE, "Execution engine: TREE_WALK or BYTECODE")
+	optimize := flag.Bool("optimize", false, "Fold constants, drop unreachable code and inline small functions")
+	maxSteps := flag.Int("max-steps", 0, "Stop after this many evaluation steps, 0 for no limit")
+	maxCallDepth := flag.Int("max-call-depth", 0, "Maximum depth of nested calls, 0 for the default")
+	timeout := flag.Duration("timeout", 0, "Stop after this long, e.g. 2s, 0 for no timeout")
+	maxMemory := flag.Int64("max-memory", 0, "Stop after allocating this many bytes, 0 for no limit")
 	flag.Parse()
 
 	if mode == lang.DEBUG_MODE {
@@ -41,6 +49,14 @@ func BuildBinary() {
 
 	console := systemconsole.NewVirtualSystemConsole()
 	langOptions := lang.NewExecuationOption(console, mode, &debugLv)
+	langOptions.ModuleLoader = module.NewFileSystemLoader(filepath.Dir(*programPath))
+	langOptions.ModuleName = *programPath
+	langOptions.Engine = *engine
+	langOptions.Optimize = *optimize
+	langOptions.MaxSteps = *maxSteps
+	langOptions.MaxCallDepth = *maxCallDepth
+	langOptions.Timeout = *timeout
+	langOptions.MaxMemoryBytes = *maxMemory
 	e := &env.Env{
 		Vars: map[string]interface{}{
 			"obj": map[string]interface{}{
@@ -0,0 +1,265 @@
+//go:build js && wasm
+
+package packagebuilder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"syscall/js"
+
+	lang "theparadance.com/quan-lang/quan-lang"
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	interpreter "theparadance.com/quan-lang/src/intepreter"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// maxSafeInteger is Number.MAX_SAFE_INTEGER, the largest integer a JS number
+// holds exactly.
+const maxSafeInteger = 1<<53 - 1
+
+// jsConverter converts values between scripts and JavaScript. Arrays and
+// objects reachable more than once are converted once, so shared values and
+// cycles survive the conversion.
+type jsConverter struct {
+	env     *env.Env // the scope script functions are called in from JavaScript
+	funcs   *jsFuncs
+	objects map[*object.Object]js.Value
+	arrays  map[*object.Array]js.Value
+}
+
+func newJSConverter(e *env.Env, funcs *jsFuncs) *jsConverter {
+	return &jsConverter{
+		env:     e,
+		funcs:   funcs,
+		objects: make(map[*object.Object]js.Value),
+		arrays:  make(map[*object.Array]js.Value),
+	}
+}
+
+// jsFuncs holds the JS functions wrapping script functions of one execution,
+// so they can be released together, and the option their calls take their
+// limits from.
+type jsFuncs struct {
+	option *lang.ExecuationOption
+	funcs  []js.Func
+}
+
+func (funcs *jsFuncs) add(fn js.Func) js.Value {
+	funcs.funcs = append(funcs.funcs, fn)
+	return fn.Value
+}
+
+// release releases every function. Calling one afterwards logs
+// "call to released function" and returns undefined.
+func (funcs *jsFuncs) release() {
+	for _, fn := range funcs.funcs {
+		fn.Release()
+	}
+	funcs.funcs = nil
+}
+
+// toJS converts a runtime value. Integers become numbers, or BigInts when a
+// number cannot hold them exactly, and functions become JS functions.
+func (converter *jsConverter) toJS(val interface{}) js.Value {
+	switch v := val.(type) {
+	case nil, *object.Null:
+		return js.Null()
+	case bool, string, float64:
+		return js.ValueOf(v)
+	case int:
+		return jsInteger(int64(v))
+	case int64:
+		return jsInteger(v)
+	case *object.Array:
+		if arr, ok := converter.arrays[v]; ok {
+			return arr
+		}
+		arr := js.Global().Get("Array").New(v.Len())
+		converter.arrays[v] = arr
+		for i, item := range v.Items {
+			arr.SetIndex(i, converter.toJS(item))
+		}
+		return arr
+	case *object.Object:
+		if obj, ok := converter.objects[v]; ok {
+			return obj
+		}
+		obj := js.Global().Get("Object").New()
+		converter.objects[v] = obj
+		converter.setProperties(obj, v)
+		return obj
+	case object.Properties:
+		// Go structs, with their fields only
+		obj := js.Global().Get("Object").New()
+		converter.setProperties(obj, v)
+		return obj
+	case expression.FuncDef, *env.Closure, env.BuiltinFunc, env.Callable:
+		return converter.function(v)
+	default:
+		return js.Undefined()
+	}
+}
+
+func (converter *jsConverter) setProperties(obj js.Value, props object.Properties) {
+	for _, key := range props.Keys() {
+		val, _ := props.GetProperty(key)
+		obj.Set(key, converter.toJS(val))
+	}
+}
+
+// function wraps a script function for JavaScript. Each call runs under
+// limits of its own, made from the execution's option, since the limits of
+// the execution are spent, or cancelled by its timeout, once it returns. An
+// error is returned as an Error object.
+func (converter *jsConverter) function(fn interface{}) js.Value {
+	return converter.funcs.add(js.FuncOf(func(this js.Value, args []js.Value) (result any) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = js.Global().Get("Error").New(panicMessage(r))
+			}
+		}()
+		limits, cancel := lang.NewLimits(context.Background(), converter.funcs.option)
+		defer cancel()
+		scope := env.NewEnv(converter.env)
+		scope.Limits = limits
+		callee := fn
+		if closure, ok := fn.(*env.Closure); ok {
+			// a closure is called in its own scope
+			closureScope := env.NewEnv(closure.Env)
+			closureScope.Limits = limits
+			callee = &env.Closure{Func: closure.Func, Env: closureScope}
+		}
+
+		from := newJSConverter(converter.env, converter.funcs)
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = from.fromJS(arg)
+		}
+		return newJSConverter(converter.env, converter.funcs).toJS(interpreter.CallValue(callee, values, scope))
+	}))
+}
+
+// fromJS converts a JS value. null and undefined become null, integral
+// numbers integers like in parsed JSON, and functions builtins.
+func (converter *jsConverter) fromJS(val js.Value) interface{} {
+	return converter.fromJSValue(val, js.Global().Get("Map").New(), &[]interface{}{})
+}
+
+// fromJSValue converts val, recording the arrays and objects converted so
+// far in seen, a JS Map from the JS value to its index in converted.
+func (converter *jsConverter) fromJSValue(val js.Value, seen js.Value, converted *[]interface{}) interface{} {
+	switch val.Type() {
+	case js.TypeNull, js.TypeUndefined:
+		return object.NULL
+	case js.TypeBoolean:
+		return val.Bool()
+	case js.TypeString:
+		return val.String()
+	case js.TypeNumber:
+		n := val.Float()
+		if n == math.Trunc(n) && math.Abs(n) <= maxSafeInteger {
+			return int(n)
+		}
+		return n
+	case js.TypeFunction:
+		return converter.builtin(val)
+	case js.TypeObject:
+		if index := seen.Call("get", val); index.Type() == js.TypeNumber {
+			return (*converted)[index.Int()]
+		}
+		if val.InstanceOf(js.Global().Get("Array")) {
+			arr := object.NewArray(make([]interface{}, val.Length()))
+			seen.Call("set", val, len(*converted))
+			*converted = append(*converted, arr)
+			for i := range arr.Items {
+				arr.Items[i] = converter.fromJSValue(val.Index(i), seen, converted)
+			}
+			return arr
+		}
+		obj := object.NewObject()
+		seen.Call("set", val, len(*converted))
+		*converted = append(*converted, obj)
+		keys := js.Global().Get("Object").Call("keys", val)
+		for i := 0; i < keys.Length(); i++ {
+			key := keys.Index(i).String()
+			obj.SetProperty(key, converter.fromJSValue(val.Get(key), seen, converted))
+		}
+		return obj
+	default:
+		return object.NULL
+	}
+}
+
+// builtin wraps a JS function for scripts. An exception it throws becomes
+// the error of the call.
+func (converter *jsConverter) builtin(fn js.Value) env.BuiltinFunc {
+	return func(args []interface{}) (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &errorexception.RuntimeError{Message: panicMessage(r)}
+			}
+		}()
+		to := newJSConverter(converter.env, converter.funcs)
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = to.toJS(arg)
+		}
+		return newJSConverter(converter.env, converter.funcs).fromJS(fn.Invoke(values...)), nil
+	}
+}
+
+// jsVars converts the vars of the options object into script variables.
+func jsVars(val js.Value, e *env.Env, funcs *jsFuncs) map[string]interface{} {
+	vars := make(map[string]interface{})
+	if obj, ok := newJSConverter(e, funcs).fromJS(val).(*object.Object); ok {
+		for _, key := range obj.Keys() {
+			vars[key], _ = obj.GetProperty(key)
+		}
+	}
+	return vars
+}
+
+// jsOutputs converts the variables and functions of the program scope, in
+// name order.
+func jsOutputs(e *env.Env, funcs *jsFuncs) js.Value {
+	values := make(map[string]interface{}, len(e.Vars)+len(e.Funcs))
+	for name, fn := range e.Funcs {
+		values[name] = fn
+	}
+	for name, val := range e.Vars {
+		values[name] = val
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	converter := newJSConverter(e, funcs)
+	outputs := js.Global().Get("Object").New()
+	for _, name := range names {
+		outputs.Set(name, converter.toJS(values[name]))
+	}
+	return outputs
+}
+
+func jsInteger(n int64) js.Value {
+	if n > maxSafeInteger || n < -maxSafeInteger {
+		return js.Global().Get("BigInt").Invoke(fmt.Sprint(n))
+	}
+	return js.ValueOf(float64(n))
+}
+
+func panicMessage(r interface{}) string {
+	switch v := r.(type) {
+	case errorexception.QuanLangEngineError:
+		return v.GetMessage()
+	case error:
+		return v.Error()
+	default:
+		return fmt.Sprint(v)
+	}
+}
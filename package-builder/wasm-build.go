@@ -1,17 +1,25 @@
+//go:build js && wasm
+
 package packagebuilder
 
 import (
 	"encoding/json"
+	"fmt"
 	"syscall/js"
+	"time"
 
 	lang "theparadance.com/quan-lang/quan-lang"
 	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
 	debuglevel "theparadance.com/quan-lang/src/debug/debug-level"
 	"theparadance.com/quan-lang/src/env"
 	"theparadance.com/quan-lang/src/helper"
+	"theparadance.com/quan-lang/src/module"
 	systemconsole "theparadance.com/quan-lang/src/system-console"
 )
 
+// moduleCache keeps the modules passed to execute() parsed between calls.
+var moduleCache = module.NewCache()
+
 func BuildWasm() {
 	c := make(chan struct{}, 0)
 	js.Global().Set("execute", js.FuncOf(executeForWasm))
@@ -21,7 +29,6 @@ func BuildWasm() {
 func executeForWasm(this js.Value, args []js.Value) (exeResult any) {
 	obj := args[0]
 	mode := obj.Get("mode").String()
-	vars := jsObjectToMap(obj.Get("vars"))
 	program := obj.Get("program").String()
 	debugLvVal := obj.Get("debugLv")
 
@@ -38,21 +45,119 @@ func executeForWasm(this js.Value, args []js.Value) (exeResult any) {
 		println("Quan Lang Engine", mode)
 	}
 
-	console := systemconsole.NewVirtualSystemConsole()
+	var console systemconsole.SystemConsole = systemconsole.NewVirtualSystemConsole()
+	// onConsole: a function called with { level, text, timestamp } for every
+	// print as it happens
+	if onConsoleVal := obj.Get("onConsole"); onConsoleVal.Type() == js.TypeFunction {
+		console = systemconsole.NewStreamSystemConsole(func(entry systemconsole.Entry) {
+			onConsoleVal.Invoke(map[string]interface{}{
+				"level":     entry.Level,
+				"text":      entry.Text,
+				"timestamp": entry.Timestamp.UnixMilli(),
+			})
+		})
+	}
 	langOptions := lang.NewExecuationOption(console, mode, &debugLevels)
-	e := &env.Env{
-		Vars:    vars,
+	if engineVal := obj.Get("engine"); engineVal.Type() == js.TypeString {
+		langOptions.Engine = engineVal.String()
+	}
+	if optimizeVal := obj.Get("optimize"); optimizeVal.Type() == js.TypeBoolean {
+		langOptions.Optimize = optimizeVal.Bool()
+	}
+	if maxStepsVal := obj.Get("maxSteps"); maxStepsVal.Type() == js.TypeNumber {
+		langOptions.MaxSteps = maxStepsVal.Int()
+	}
+	if maxCallDepthVal := obj.Get("maxCallDepth"); maxCallDepthVal.Type() == js.TypeNumber {
+		langOptions.MaxCallDepth = maxCallDepthVal.Int()
+	}
+	if timeoutVal := obj.Get("timeoutMs"); timeoutVal.Type() == js.TypeNumber {
+		langOptions.Timeout = time.Duration(timeoutVal.Int()) * time.Millisecond
+	}
+	if maxMemoryVal := obj.Get("maxMemoryBytes"); maxMemoryVal.Type() == js.TypeNumber {
+		langOptions.MaxMemoryBytes = int64(maxMemoryVal.Float())
+	}
+	if policyVal := obj.Get("policy"); policyVal.Type() == js.TypeObject {
+		policy, err := jsPolicy(policyVal)
+		if err != nil {
+			return js.ValueOf(map[string]interface{}{
+				"message": "Fail to read policy: " + err.Error(),
+				"payload": map[string]interface{}{
+					"program": program,
+					"inputs":  obj.Get("vars"),
+					"outputs": nil,
+					"state":   nil,
+					"console": nil,
+					"tokens":  nil,
+					"ast":     nil,
+				},
+			})
+		}
+		langOptions.Policy = policy
+	}
+	// modules: { "lib.qlang": "export fn ..." } makes those sources importable
+	if modulesVal := obj.Get("modules"); modulesVal.Type() == js.TypeObject {
+		modules := make(map[string]string)
+		names := js.Global().Get("Object").Call("keys", modulesVal)
+		for i := 0; i < names.Length(); i++ {
+			name := names.Index(i).String()
+			if source := modulesVal.Get(name); source.Type() == js.TypeString {
+				modules[name] = source.String()
+			}
+		}
+		langOptions.ModuleLoader = module.NewMapLoader(modules)
+		langOptions.ModuleCache = moduleCache
+		langOptions.ModuleName = "main.qlang"
+	}
+	funcs := &jsFuncs{option: langOptions}
+	host := &env.Env{
 		Builtin: builtinfunc.BuildInFuncs(console),
 	}
+	e := &env.Env{Parent: host}
+	vars := jsVars(obj.Get("vars"), e, funcs)
+	for name, val := range vars {
+		// functions come with every call, so they are builtins rather than
+		// part of the saved state
+		if fn, ok := val.(env.BuiltinFunc); ok {
+			host.Builtin[name] = fn
+			delete(vars, name)
+		}
+	}
+	e.Vars = vars
+	// state: the "state" output of an earlier execution to resume from
+	if stateVal := obj.Get("state"); stateVal.Type() == js.TypeString && stateVal.String() != "" {
+		restored, err := env.Restore([]byte(stateVal.String()), host)
+		if err != nil {
+			return js.ValueOf(map[string]interface{}{
+				"message": "Fail to restore state: " + err.Error(),
+				"payload": map[string]interface{}{
+					"program": program,
+					"inputs":  obj.Get("vars"),
+					"outputs": nil,
+					"state":   nil,
+					"console": nil,
+					"tokens":  nil,
+					"ast":     nil,
+				},
+			})
+		}
+		for name, val := range vars {
+			if !restored.UpdateVar(name, val) {
+				restored.SetVar(name, val)
+			}
+		}
+		e = restored
+	}
 
 	defer func() {
 		if r := recover(); r != nil {
+			funcs.release()
 			exeResult = js.ValueOf(map[string]interface{}{
 				"message": "Fail to run program",
 				"payload": map[string]interface{}{
 					"program": program,
-					"inputs":  vars,
+					"inputs":  obj.Get("vars"),
 					"outputs": nil,
+					"state":   nil,
 					"console": console.String(),
 					"tokens":  nil,
 					"ast":     nil,
@@ -65,13 +170,29 @@ func executeForWasm(this js.Value, args []js.Value) (exeResult any) {
 	tokensResult, _ := json.Marshal(helper.TokenToJson(result.Tokens))
 	expressionResult, _ := json.Marshal(helper.ExpressionToJson(result.Expression))
 
+	var state interface{}
+	if data, err := result.Env.Snapshot(host); err == nil {
+		state = string(data)
+	} else {
+		systemconsole.PrintError(console, err.Error())
+	}
+
+	outputs := jsOutputs(result.Env, funcs)
+	// dispose: releases the functions in outputs, and any handed to host
+	// functions, once JavaScript is done calling them
+	dispose := funcs.add(js.FuncOf(func(this js.Value, args []js.Value) any {
+		funcs.release()
+		return nil
+	}))
 	exeResult = js.ValueOf(map[string]interface{}{
 		"message": "Program executed successfully",
 		"payload": map[string]interface{}{
 			"program": program,
-			"inputs":  vars,
-			"outputs": filterPrimative(result.Env),
-			"console": result.ConsoleMessages,
+			"inputs":  obj.Get("vars"),
+			"outputs": outputs,
+			"dispose": dispose,
+			"state":   state,
+			"console": console.String(),
 			"tokens":  string(tokensResult),
 			"ast":     string(expressionResult),
 		},
@@ -79,75 +200,60 @@ func executeForWasm(this js.Value, args []js.Value) (exeResult any) {
 	return
 }
 
-func filterPrimative(env *env.Env) map[string]interface{} {
-	result := make(map[string]interface{})
-	for name, item := range env.Vars {
-		switch item.(type) {
-		case int, float64, string, bool:
-			result[name] = item
-		}
+// jsPolicy reads an execution policy such as
+// { builtins: ["print"], fetch: { allowedHosts: ["api.example.com"] } }.
+// A list that is set but not an array of strings is an error rather than
+// no restriction.
+func jsPolicy(val js.Value) (*builtinfunc.Policy, error) {
+	policy := &builtinfunc.Policy{}
+	var err error
+	if policy.Builtins, err = jsPolicyList(val, "builtins"); err != nil {
+		return nil, fmt.Errorf("policy.%w", err)
 	}
-	return result
-}
-
-func jsObjectToMap(obj js.Value) map[string]interface{} {
-	result := make(map[string]interface{})
-	keys := js.Global().Get("Object").Call("keys", obj)
-
-	for i := 0; i < keys.Length(); i++ {
-		key := keys.Index(i).String()
-		val := obj.Get(key)
-
-		// Convert js.Value to Go value (basic types)
-		var goVal interface{}
-		switch val.Type() {
-		case js.TypeString:
-			goVal = val.String()
-		case js.TypeNumber:
-			goVal = val.Float()
-		case js.TypeBoolean:
-			goVal = val.Bool()
-		case js.TypeObject:
-			if val.InstanceOf(js.Global().Get("Array")) {
-				// Handle arrays as []interface{}
-				arr := make([]interface{}, val.Length())
-				for j := 0; j < val.Length(); j++ {
-					arr[j] = convertValue(val.Index(j)) // Use a helper function for recursive conversion
-				}
-				goVal = arr
-			} else {
-				// Optional: recurse into nested object
-				goVal = jsObjectToMap(val)
-			}
-		default:
-			goVal = val.String() // fallback
+	fetch := val.Get("fetch")
+	if fetch.IsUndefined() {
+		return policy, nil
+	}
+	if fetch.Type() != js.TypeObject {
+		return nil, fmt.Errorf("policy.fetch must be an object")
+	}
+	for _, list := range []struct {
+		name   string
+		result *[]string
+	}{
+		{"allowedHosts", &policy.Fetch.AllowedHosts},
+		{"allowedMethods", &policy.Fetch.AllowedMethods},
+		{"allowedSchemes", &policy.Fetch.AllowedSchemes},
+	} {
+		if *list.result, err = jsPolicyList(fetch, list.name); err != nil {
+			return nil, fmt.Errorf("policy.fetch.%w", err)
 		}
-
-		result[key] = goVal
 	}
-
-	return result
+	if maxBytes := fetch.Get("maxResponseBytes"); maxBytes.Type() == js.TypeNumber {
+		policy.Fetch.MaxResponseBytes = int64(maxBytes.Float())
+	}
+	if allowPrivate := fetch.Get("allowPrivateNetworks"); allowPrivate.Type() == js.TypeBoolean {
+		policy.Fetch.AllowPrivateNetworks = allowPrivate.Bool()
+	}
+	return policy, nil
 }
 
-func convertValue(val js.Value) interface{} {
-	switch val.Type() {
-	case js.TypeString:
-		return val.String()
-	case js.TypeNumber:
-		return val.Float()
-	case js.TypeBoolean:
-		return val.Bool()
-	case js.TypeObject:
-		if val.InstanceOf(js.Global().Get("Array")) {
-			arr := make([]interface{}, val.Length())
-			for i := 0; i < val.Length(); i++ {
-				arr[i] = convertValue(val.Index(i))
-			}
-			return arr
-		} else {
-			return jsObjectToMap(val)
+// jsPolicyList reads the list name of a policy: nil when it is not set, an
+// error when it is set to anything but an array of strings.
+func jsPolicyList(val js.Value, name string) ([]string, error) {
+	list := val.Get(name)
+	if list.IsUndefined() {
+		return nil, nil
+	}
+	if list.Type() != js.TypeObject || !list.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("%s must be an array of strings", name)
+	}
+	result := make([]string, list.Length())
+	for i := range result {
+		if list.Index(i).Type() != js.TypeString {
+			return nil, fmt.Errorf("%s must be an array of strings", name)
 		}
-	default:
-		return val.String()
+		result[i] = list.Index(i).String()
 	}
+	return result, nil
 }
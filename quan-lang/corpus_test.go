@@ -0,0 +1,116 @@
+package lang_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lang "theparadance.com/quan-lang/quan-lang"
+	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
+	debuglevel "theparadance.com/quan-lang/src/debug/debug-level"
+	"theparadance.com/quan-lang/src/env"
+	systemconsole "theparadance.com/quan-lang/src/system-console"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected output of test/corpus")
+
+var corpusEngines = []struct {
+	name     string
+	engine   string
+	optimize bool
+}{
+	{"interpreter", lang.TREE_WALK_ENGINE, false},
+	{"vm", lang.BYTECODE_ENGINE, false},
+	{"optimized", lang.TREE_WALK_ENGINE, true},
+}
+
+// TestCorpus runs every script of test/corpus on the tree-walking
+// interpreter, on the bytecode VM and on the interpreter with the optimizer
+// enabled, and fails for any engine whose console output differs from the
+// .out file next to the script. go test -run TestCorpus -update rewrites the
+// .out files from the interpreter. go run ./test/engine-check runs the same
+// scripts with timings.
+func TestCorpus(t *testing.T) {
+	files := corpusFiles(t)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(file, ".qlang") + ".out"
+			if *update {
+				output := runCorpusScript(string(source), lang.TREE_WALK_ENGINE, false)
+				if err := os.WriteFile(golden, []byte(output), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("no expected output, run go test -run TestCorpus -update: %v", err)
+			}
+
+			for _, engine := range corpusEngines {
+				got := runCorpusScript(string(source), engine.engine, engine.optimize)
+				if got != string(want) {
+					t.Errorf("%s output differs\n--- got\n%s--- want\n%s", engine.name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCorpus times every script of test/corpus on each engine, e.g.
+//
+//	go test ./quan-lang -run '^$' -bench 'Corpus/recursion'
+func BenchmarkCorpus(b *testing.B) {
+	for _, file := range corpusFiles(b) {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".qlang")
+		for _, engine := range corpusEngines {
+			b.Run(name+"/"+engine.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					runCorpusScript(string(source), engine.engine, engine.optimize)
+				}
+			})
+		}
+	}
+}
+
+func corpusFiles(tb testing.TB) []string {
+	files, err := filepath.Glob(filepath.Join("..", "test", "corpus", "*.qlang"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no scripts found in test/corpus: %v", err)
+	}
+	return files
+}
+
+func runCorpusScript(source string, engine string, optimize bool) (output string) {
+	console := systemconsole.NewVirtualSystemConsole()
+	debugLevels := []debuglevel.DebugLevel{}
+	option := lang.NewExecuationOption(console, lang.RELEASE_MODE, &debugLevels)
+	option.Engine = engine
+	option.Optimize = optimize
+	e := &env.Env{
+		Vars: map[string]interface{}{
+			"host": map[string]interface{}{"name": "q", "age": 3},
+		},
+		Builtin: builtinfunc.BuildInFuncs(console),
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			output = console.String() + fmt.Sprintf("panic: %v\n", r)
+			return
+		}
+		output = console.String()
+	}()
+	lang.Execuate(source, e, option)
+	return
+}
@@ -0,0 +1,189 @@
+package lang
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
+	environment "theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	interpreter "theparadance.com/quan-lang/src/intepreter"
+	lexer "theparadance.com/quan-lang/src/lexer"
+	"theparadance.com/quan-lang/src/module"
+	"theparadance.com/quan-lang/src/optimizer"
+	parser "theparadance.com/quan-lang/src/paraser"
+	"theparadance.com/quan-lang/src/resolver"
+	systemconsole "theparadance.com/quan-lang/src/system-console"
+	"theparadance.com/quan-lang/src/token"
+	"theparadance.com/quan-lang/src/vm"
+)
+
+// Diagnostic is a problem found while compiling a program.
+type Diagnostic struct {
+	Stage   string `json:"stage"` // "lexer", "parser" or "resolver"
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return d.Stage + ": " + d.Message
+}
+
+// Program is a lexed and parsed program. Apart from the optimized copy made
+// by the first run with Optimize set, it is never modified after Compile, so
+// one Program can be run any number of times, from several goroutines at
+// once, each run getting its own environment.
+type Program struct {
+	source    string
+	tokens    []token.Token
+	ast       []expression.Expr
+	freeNames []string
+	bytecode  *vm.Program // nil when the VM cannot run the program
+
+	optimizeOnce sync.Once
+	optimized    *Program
+}
+
+// Compile lexes and parses source once. When the source cannot be compiled
+// the returned Program is nil and the diagnostics say why.
+func Compile(source string) (*Program, []Diagnostic) {
+	stage := "lexer"
+	var program *Program
+	diagnostics := func() (diagnostics []Diagnostic) {
+		defer func() {
+			if r := recover(); r != nil {
+				diagnostics = []Diagnostic{{Stage: stage, Message: recoveredError(r).Error()}}
+			}
+		}()
+
+		tokens := lexer.Lex(source)
+		stage = "parser"
+		p := parser.Parser{Tokens: tokens}
+		ast := p.Parse()
+		stage = "resolver"
+		program = newProgram(source, tokens, ast)
+		return nil
+	}()
+	return program, diagnostics
+}
+
+func newProgram(source string, tokens []token.Token, ast []expression.Expr) *Program {
+	resolved := resolver.Resolve(ast)
+	program := &Program{source: source, tokens: tokens, ast: resolved.AST, freeNames: resolved.FreeNames}
+	program.bytecode, _ = vm.Compile(resolved.AST)
+	return program
+}
+
+// optimizedProgram returns the program rewritten by the optimizer. It is
+// made once, from a fresh parse of the tokens, and shared by later runs.
+func (program *Program) optimizedProgram() *Program {
+	program.optimizeOnce.Do(func() {
+		p := parser.Parser{Tokens: program.tokens}
+		program.optimized = newProgram(program.source, program.tokens, optimizer.Optimize(p.Parse()))
+	})
+	return program.optimized
+}
+
+func (program *Program) Source() string {
+	return program.source
+}
+
+// FreeNames returns the names the program uses without defining them. Run
+// fails before executing anything unless each is a variable in vars or a
+// builtin.
+func (program *Program) FreeNames() []string {
+	return append([]string(nil), program.freeNames...)
+}
+
+// Run executes the program in a fresh environment holding vars, the
+// builtin functions and the Funcs of option, above the BaseEnv of option.
+// Errors raised by the program are returned instead of panicking; they are
+// also written to the console as in Execuate. The run stops with a
+// LimitExceededError when ctx is done or a limit of option is exceeded.
+// With Optimize set on option, the run uses the optimized program, made by
+// the first such run. option may be nil, in which case a new virtual
+// console is used in release mode.
+func (program *Program) Run(ctx context.Context, vars map[string]interface{}, option *ExecuationOption) (result ExecuationResult, err error) {
+	if option == nil {
+		option = &ExecuationOption{Mode: RELEASE_MODE}
+	}
+	if option.Optimize {
+		program = program.optimizedProgram()
+	}
+	console := option.Console
+	if console == nil {
+		console = systemconsole.NewVirtualSystemConsole()
+	}
+
+	if option.BaseEnv != nil && !option.BaseEnv.Frozen() {
+		err := &errorexception.RuntimeError{Message: "BaseEnv must be frozen with Env.Freeze"}
+		return ExecuationResult{ConsoleMessages: console.String()}, err
+	}
+	host := &environment.Env{
+		Vars:    make(map[string]interface{}, len(vars)),
+		Builtin: builtinfunc.BuildInFuncs(console),
+		Parent:  option.BaseEnv,
+	}
+	for name, val := range vars {
+		host.Vars[name] = environment.FromHost(val)
+	}
+	for name, fn := range option.Funcs {
+		host.Builtin[name] = fn
+	}
+	limits, cancel := NewLimits(ctx, option)
+	defer cancel()
+	e := environment.NewEnv(sandbox(host, option, console, limits))
+	e.Limits = limits
+	if option.ModuleLoader != nil {
+		e.Modules = module.NewRegistry(option.ModuleLoader, option.ModuleCache)
+		e.ModuleName = option.ModuleName
+	}
+
+	tokens, ast := program.tokens, program.ast
+	result = ExecuationResult{
+		Env:        e,
+		Tokens:     &tokens,
+		Expression: &ast,
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+			systemconsole.PrintError(console, err.Error())
+		}
+		result.ConsoleMessages = console.String()
+	}()
+
+	undefined := resolver.Result{FreeNames: program.freeNames}.Undefined(definedIn(host))
+	if len(undefined) > 0 {
+		err := &errorexception.RuntimeError{
+			Message: "Undefined variable: " + strings.Join(undefined, ", "),
+		}
+		systemconsole.PrintError(console, err.Error())
+		return result, err
+	}
+
+	if option.Engine == BYTECODE_ENGINE && program.bytecode != nil {
+		vm.New(program.bytecode, e).Run()
+		return result, nil
+	}
+	for _, expr := range program.ast {
+		interpreter.Eval(expr, e)
+	}
+	return result, nil
+}
+
+// recoveredError turns a value recovered from a lexer, parser or
+// interpreter panic into an error.
+func recoveredError(r interface{}) error {
+	switch v := r.(type) {
+	case error:
+		return v
+	case string:
+		return &errorexception.RuntimeError{Message: v}
+	default:
+		return &errorexception.RuntimeError{Message: fmt.Sprint(v)}
+	}
+}
@@ -1,15 +1,27 @@
 package lang
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
 	debuglevel "theparadance.com/quan-lang/src/debug/debug-level"
 	environment "theparadance.com/quan-lang/src/env"
 	errorexception "theparadance.com/quan-lang/src/error-exception"
 	"theparadance.com/quan-lang/src/expression"
 	interpreter "theparadance.com/quan-lang/src/intepreter"
 	lexer "theparadance.com/quan-lang/src/lexer"
+	"theparadance.com/quan-lang/src/module"
+	"theparadance.com/quan-lang/src/optimizer"
 	parser "theparadance.com/quan-lang/src/paraser"
+	"theparadance.com/quan-lang/src/resolver"
 	systemconsole "theparadance.com/quan-lang/src/system-console"
 	"theparadance.com/quan-lang/src/token"
+	"theparadance.com/quan-lang/src/vm"
 	"theparadance.com/quan-lang/utils"
 )
 
@@ -18,12 +30,69 @@ var (
 	RELEASE_MODE = "RELEASE"
 )
 
+var (
+	// TREE_WALK_ENGINE evaluates the AST directly. It is the default.
+	TREE_WALK_ENGINE = "TREE_WALK"
+	// BYTECODE_ENGINE compiles the program to bytecode and runs it on the
+	// VM. Programs using features the VM lacks (match, destructuring,
+	// modules) run on the tree-walking interpreter instead.
+	BYTECODE_ENGINE = "BYTECODE"
+)
+
+// DEFAULT_MAX_CALL_DEPTH is the call depth limit used when
+// ExecuationOption.MaxCallDepth is zero. It stops runaway recursion long
+// before it could overflow the Go stack.
+var DEFAULT_MAX_CALL_DEPTH = 10000
+
 type Mode string
 
 type ExecuationOption struct {
 	Mode       string
 	Console    systemconsole.SystemConsole
 	DebugLevel []debuglevel.DebugLevel
+	Engine     string
+	// Optimize folds constants, drops unreachable code and inlines small
+	// functions before the program runs.
+	Optimize bool
+
+	// ModuleLoader enables `import`. Leave nil to disallow imports.
+	ModuleLoader module.ModuleLoader
+	// ModuleName is the name of the program itself, used to resolve
+	// relative imports, e.g. the path of the script file.
+	ModuleName string
+	// ModuleCache keeps imported modules parsed across the executions that
+	// share it. nil parses them again in every execution.
+	ModuleCache *module.Cache
+
+	// MaxSteps stops the program after that many evaluation steps; 0 means
+	// no limit. A step is an evaluated expression on the interpreter and an
+	// instruction on the bytecode VM.
+	MaxSteps int
+	// MaxCallDepth limits how deeply function calls nest. 0 uses
+	// DEFAULT_MAX_CALL_DEPTH and a negative value disables the limit.
+	MaxCallDepth int
+	// Timeout stops the program after that much time; 0 means no timeout.
+	Timeout time.Duration
+	// MaxMemoryBytes stops the program once the strings, arrays and objects
+	// it created add up to more than that many bytes; 0 means no limit.
+	// Every allocation counts, whether or not it is still in use.
+	MaxMemoryBytes int64
+
+	// Policy restricts the builtins the program may call and the requests
+	// fetch() may send. nil allows everything.
+	Policy *builtinfunc.Policy
+	// HTTPTransport sends the requests of fetch(), for example a stand-in
+	// serving canned responses in tests. nil uses the default transport.
+	HTTPTransport http.RoundTripper
+
+	// Funcs adds host functions, for example made with environment.NewFunc,
+	// to the builtins of Program.Run, replacing builtins of the same name.
+	// With Execuate register them on the host's env instead.
+	Funcs map[string]environment.BuiltinFunc
+	// BaseEnv is an environment frozen with Env.Freeze whose variables,
+	// functions and builtins Program.Run makes available next to vars, for
+	// example a rule set shared by concurrent runs.
+	BaseEnv *environment.Env
 }
 
 func NewExecuationOption(console systemconsole.SystemConsole, mode string, debugLevel *[]debuglevel.DebugLevel) *ExecuationOption {
@@ -42,6 +111,18 @@ type ExecuationResult struct {
 }
 
 func Execuate(program string, env *environment.Env, option *ExecuationOption) (ExecuationResult, error) {
+	return ExecuateContext(context.Background(), program, env, option)
+}
+
+// ExecuateContext is Execuate with a context. The program stops when ctx is
+// done or a limit of the option is exceeded.
+//
+// Errors are not returned; the error result is always nil. Every error,
+// including syntax errors, a PolicyError and the
+// *errorexception.LimitExceededError of an exceeded limit, is printed to the
+// console and then raised as a panic, which callers must recover to inspect.
+// Program.Run returns these errors instead.
+func ExecuateContext(ctx context.Context, program string, env *environment.Env, option *ExecuationOption) (ExecuationResult, error) {
 	// p := `
 	// 	fn fact(n) {
 	// 		if (n <= 1) {
@@ -60,19 +141,22 @@ func Execuate(program string, env *environment.Env, option *ExecuationOption) (E
 
 	defer func() {
 		if r := recover(); r != nil {
-			switch r.(type) {
+			switch v := r.(type) {
+			case *errorexception.LimitExceededError, *errorexception.PolicyError:
+				systemconsole.PrintError(option.Console, v.(error).Error())
+				panic(r)
 			case errorexception.QuanLangEngineError:
-				msg := r.(errorexception.QuanLangEngineError).GetMessage()
-				option.Console.Println("[Error]: ", msg)
+				msg := v.GetMessage()
+				systemconsole.PrintError(option.Console, msg)
 				var err errorexception.QuanLangEngineError = &errorexception.RuntimeError{
 					Message: msg,
 				}
 				panic(err)
 			case error:
-				msg := r.(error).Error()
-				option.Console.Println("[Error]: ", msg)
+				systemconsole.PrintError(option.Console, v.Error())
+				panic(r)
 			default:
-				option.Console.Println("[Error]: ", r.(string))
+				systemconsole.PrintError(option.Console, fmt.Sprint(r))
 				panic(r)
 			}
 		}
@@ -104,16 +188,63 @@ func Execuate(program string, env *environment.Env, option *ExecuationOption) (E
 		println("=============================")
 	}
 
+	if option.Optimize {
+		if option.Mode == DEBUG_MODE {
+			println("Status: Optimizing program")
+		}
+		ast = optimizer.Optimize(ast)
+		if option.Mode == DEBUG_MODE && utils.ArrayItemContain(option.DebugLevel, debuglevel.OPTIMIZED_TREE) {
+			println("========== Optimized Tree ==========")
+			for _, expr := range ast {
+				utils.PrintExpression(expr, 0)
+			}
+			println("=============================")
+		}
+	}
+
+	if option.Mode == DEBUG_MODE {
+		println("Status: Resolving variables")
+	}
+	resolved := resolver.Resolve(ast)
+	if undefined := resolved.Undefined(definedIn(env)); len(undefined) > 0 {
+		panic(&errorexception.RuntimeError{
+			Message: "Undefined variable: " + strings.Join(undefined, ", "),
+		})
+	}
+	ast = resolved.AST
+
 	if option.Mode == DEBUG_MODE {
 		println("Status: Environment loaded")
 	}
-	e := environment.NewEnv(env)
+	limits, cancel := NewLimits(ctx, option)
+	defer cancel()
+	e := environment.NewEnv(sandbox(hostValues(env), option, option.Console, limits))
+	e.Limits = limits
+	if option.ModuleLoader != nil {
+		e.Modules = module.NewRegistry(option.ModuleLoader, option.ModuleCache)
+		e.ModuleName = option.ModuleName
+	}
 
 	if option.Mode == DEBUG_MODE {
 		println("Status: Executing program")
 	}
-	for _, expr := range ast {
-		_, _ = interpreter.Eval(expr, e)
+	if option.Engine == BYTECODE_ENGINE {
+		program, err := vm.Compile(ast)
+		if err == nil {
+			if option.Mode == DEBUG_MODE && utils.ArrayItemContain(option.DebugLevel, debuglevel.BYTECODE) {
+				println("========== Bytecode ==========")
+				println(vm.Disassemble(program.Main))
+				println("=============================")
+			}
+			vm.New(program, e).Run()
+		} else {
+			if option.Mode == DEBUG_MODE {
+				println("Status: Falling back to the interpreter:", err.Error())
+			}
+			evalAll(ast, e)
+		}
+	} else {
+		evalAll(ast, e)
 	}
 
 	result := ExecuationResult{
@@ -124,3 +255,125 @@ func Execuate(program string, env *environment.Env, option *ExecuationOption) (E
 	}
 	return result, nil
 }
+
+// sandbox returns the scope programs run under: env itself, or a scope
+// above it whose builtins enforce the policy, send requests through the
+// transport and stop them when the execution of limits stops, charge the
+// text of toJson and string to the memory quota as it grows, and, when env
+// is based on a frozen env, print to this execution's console instead of
+// the one shared with other executions. Modules share it, as their scopes
+// are children of the program scope's parent.
+func sandbox(env *environment.Env, option *ExecuationOption, console systemconsole.SystemConsole, limits *environment.Limits) *environment.Env {
+	shared := false
+	for scope := env; scope != nil; scope = scope.Parent {
+		shared = shared || scope.Frozen()
+	}
+	_, fetch := env.GetBuiltin("fetch")
+	quota := option.MaxMemoryBytes > 0
+	if option.Policy == nil && !fetch && !shared && !quota {
+		return env
+	}
+	builtins := make(map[string]environment.BuiltinFunc)
+	for scope := env; scope != nil; scope = scope.Parent {
+		for name, fn := range scope.Builtin {
+			if _, ok := builtins[name]; !ok {
+				builtins[name] = fn
+			}
+		}
+	}
+	overrides := make(map[string]environment.BuiltinFunc)
+	if shared {
+		own := builtinfunc.BuildInFuncs(console)
+		for _, name := range []string{"print", "println"} {
+			if _, ok := builtins[name]; ok {
+				overrides[name] = own[name]
+			}
+		}
+	}
+	if quota {
+		// toJson and string charge their text as it grows
+		for name, fn := range builtinfunc.ConversionsWithLimits(limits) {
+			if _, ok := builtins[name]; ok {
+				overrides[name] = fn
+			}
+		}
+	}
+	if option.Policy != nil {
+		for name, fn := range option.Policy.Apply(builtins, option.HTTPTransport, limits) {
+			overrides[name] = fn
+		}
+	} else if fetch {
+		overrides["fetch"] = builtinfunc.FetchWithLimits(option.HTTPTransport, limits)
+	}
+	scope := &environment.Env{
+		Builtin: overrides,
+		Parent:  env,
+	}
+	if env.Frozen() {
+		// so that shadowed variables land in the program scope
+		scope.Freeze()
+	}
+	return scope
+}
+
+// NewLimits creates the limits of one execution from the option.
+func NewLimits(ctx context.Context, option *ExecuationOption) (*environment.Limits, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if option.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, option.Timeout)
+	}
+	maxCallDepth := option.MaxCallDepth
+	if maxCallDepth == 0 {
+		maxCallDepth = DEFAULT_MAX_CALL_DEPTH
+	}
+	return environment.NewLimits(ctx, option.MaxSteps, maxCallDepth, option.MaxMemoryBytes), cancel
+}
+
+func evalAll(ast []expression.Expr, e *environment.Env) {
+	for _, expr := range ast {
+		_, _ = interpreter.Eval(expr, e)
+	}
+}
+
+// definedIn reports whether the host environment provides a name.
+func definedIn(env *environment.Env) func(name string) bool {
+	return func(name string) bool {
+		if _, ok := env.GetVar(name); ok {
+			return true
+		}
+		if _, ok := env.GetFunc(name); ok {
+			return true
+		}
+		_, ok := env.GetBuiltin(name)
+		return ok
+	}
+}
+
+// hostValues returns env, or a scope above it holding the Go maps, slices
+// and structs of the host's variables converted into runtime values, which
+// shadow the originals for this execution. The host's maps are only read,
+// so executions sharing them do not race and the host keeps its values.
+// Frozen scopes were converted by Freeze.
+func hostValues(env *environment.Env) *environment.Env {
+	converted := make(map[string]interface{})
+	seen := make(map[string]bool)
+	for scope := env; scope != nil && !scope.Frozen(); scope = scope.Parent {
+		for name, val := range scope.Vars {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			// values of another type, or of an incomparable one, were
+			// converted
+			v := environment.FromHost(val)
+			t := reflect.TypeOf(v)
+			if t != reflect.TypeOf(val) || (t != nil && (!t.Comparable() || v != val)) {
+				converted[name] = v
+			}
+		}
+	}
+	if len(converted) == 0 {
+		return env
+	}
+	return &environment.Env{Vars: converted, Parent: env}
+}
@@ -1,24 +1,29 @@
 package array
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
 
-type array[T comparable] struct {
+	"theparadance.com/quan-lang/src/object"
+)
+
+type Array[T comparable] struct {
 	array *[]T
 }
 
-func NewArray[T comparable](arr *[]T) *array[T] {
-	return &array[T]{
+func NewArray[T comparable](arr *[]T) *Array[T] {
+	return &Array[T]{
 		array: arr,
 	}
 }
 
-func (a *array[T]) ForEach(callback func(item *T, index int)) {
+func (a *Array[T]) ForEach(callback func(item *T, index int)) {
 	for i, item := range *a.array {
 		callback(&item, i)
 	}
 }
 
-func (a *array[T]) Map(callback func(item T, index int) any) []any {
+func (a *Array[T]) Map(callback func(item T, index int) any) []any {
 	result := make([]any, len(*a.array))
 	for i, item := range *a.array {
 		result[i] = callback(item, i)
@@ -26,7 +31,7 @@ func (a *array[T]) Map(callback func(item T, index int) any) []any {
 	return result
 }
 
-func (a *array[T]) Filter(callback func(item T, index int) bool) []T {
+func (a *Array[T]) Filter(callback func(item T, index int) bool) []T {
 	result := make([]T, 0)
 	for i, item := range *a.array {
 		if callback(item, i) {
@@ -36,7 +41,7 @@ func (a *array[T]) Filter(callback func(item T, index int) bool) []T {
 	return result
 }
 
-func (a *array[T]) Reduce(callback func(accumulator any, item T, index int) any, initialValue any) any {
+func (a *Array[T]) Reduce(callback func(accumulator any, item T, index int) any, initialValue any) any {
 	accumulator := initialValue
 	for i, item := range *a.array {
 		accumulator = callback(accumulator, item, i)
@@ -44,7 +49,7 @@ func (a *array[T]) Reduce(callback func(accumulator any, item T, index int) any,
 	return accumulator
 }
 
-func (a *array[T]) Find(callback func(item T, index int) bool) (T, bool) {
+func (a *Array[T]) Find(callback func(item T, index int) bool) (T, bool) {
 	for i, item := range *a.array {
 		if callback(item, i) {
 			return item, true
@@ -54,7 +59,7 @@ func (a *array[T]) Find(callback func(item T, index int) bool) (T, bool) {
 	return zeroValue, false
 }
 
-func (a *array[T]) FindIndex(callback func(item T, index int) bool) int {
+func (a *Array[T]) FindIndex(callback func(item T, index int) bool) int {
 	for i, item := range *a.array {
 		if callback(item, i) {
 			return i
@@ -63,7 +68,7 @@ func (a *array[T]) FindIndex(callback func(item T, index int) bool) int {
 	return -1
 }
 
-func (a *array[T]) Some(callback func(item T, index int) bool) bool {
+func (a *Array[T]) Some(callback func(item T, index int) bool) bool {
 	for i, item := range *a.array {
 		if callback(item, i) {
 			return true
@@ -72,7 +77,7 @@ func (a *array[T]) Some(callback func(item T, index int) bool) bool {
 	return false
 }
 
-func (a *array[T]) Every(callback func(item T, index int) bool) bool {
+func (a *Array[T]) Every(callback func(item T, index int) bool) bool {
 	for i, item := range *a.array {
 		if !callback(item, i) {
 			return false
@@ -81,25 +86,25 @@ func (a *array[T]) Every(callback func(item T, index int) bool) bool {
 	return true
 }
 
-func (a *array[T]) Length() int {
+func (a *Array[T]) Length() int {
 	return len(*a.array)
 }
 
-func (a *array[T]) Clear() {
+func (a *Array[T]) Clear() {
 	*a.array = []T{}
 }
 
-func (a *array[T]) ToArray() []T {
+func (a *Array[T]) ToArray() []T {
 	return *a.array
 }
 
-func (a *array[T]) Copy() *array[T] {
+func (a *Array[T]) Copy() *Array[T] {
 	newArray := make([]T, len(*a.array))
 	copy(newArray, *a.array)
 	return NewArray(&newArray)
 }
 
-func (a *array[T]) Get(index int) (T, bool) {
+func (a *Array[T]) Get(index int) (T, bool) {
 	if index < 0 || index >= len(*a.array) {
 		var zeroValue T
 		return zeroValue, false
@@ -107,7 +112,7 @@ func (a *array[T]) Get(index int) (T, bool) {
 	return (*a.array)[index], true
 }
 
-func (a *array[T]) Set(index int, value T) bool {
+func (a *Array[T]) Set(index int, value T) bool {
 	if index < 0 || index >= len(*a.array) {
 		return false
 	}
@@ -115,11 +120,11 @@ func (a *array[T]) Set(index int, value T) bool {
 	return true
 }
 
-func (a *array[T]) Push(value T) {
+func (a *Array[T]) Push(value T) {
 	*a.array = append(*a.array, value)
 }
 
-func (a *array[T]) Pop() (T, bool) {
+func (a *Array[T]) Pop() (T, bool) {
 	if len(*a.array) == 0 {
 		var zeroValue T
 		return zeroValue, false
@@ -129,7 +134,7 @@ func (a *array[T]) Pop() (T, bool) {
 	return value, true
 }
 
-func (a *array[T]) Shift() (T, bool) {
+func (a *Array[T]) Shift() (T, bool) {
 	if len(*a.array) == 0 {
 		var zeroValue T
 		return zeroValue, false
@@ -139,11 +144,11 @@ func (a *array[T]) Shift() (T, bool) {
 	return value, true
 }
 
-func (a *array[T]) Unshift(value T) {
+func (a *Array[T]) Unshift(value T) {
 	*a.array = append([]T{value}, *a.array...)
 }
 
-func (a *array[T]) IndexOf(value T) int {
+func (a *Array[T]) IndexOf(value T) int {
 	for i, item := range *a.array {
 		if item == value {
 			return i
@@ -152,7 +157,7 @@ func (a *array[T]) IndexOf(value T) int {
 	return -1
 }
 
-func (a *array[T]) LastIndexOf(value T) int {
+func (a *Array[T]) LastIndexOf(value T) int {
 	for i := len(*a.array) - 1; i >= 0; i-- {
 		if (*a.array)[i] == value {
 			return i
@@ -161,7 +166,7 @@ func (a *array[T]) LastIndexOf(value T) int {
 	return -1
 }
 
-func (a *array[T]) Slice(start, end int) []T {
+func (a *Array[T]) Slice(start, end int) []T {
 	if start < 0 {
 		start = 0
 	}
@@ -174,14 +179,14 @@ func (a *array[T]) Slice(start, end int) []T {
 	return (*a.array)[start:end]
 }
 
-func (a *array[T]) Reverse() {
+func (a *Array[T]) Reverse() {
 	n := len(*a.array)
 	for i := 0; i < n/2; i++ {
 		(*a.array)[i], (*a.array)[n-i-1] = (*a.array)[n-i-1], (*a.array)[i]
 	}
 }
 
-func (a *array[T]) Sort(comparator func(a, b T) int) {
+func (a *Array[T]) Sort(comparator func(a, b T) int) {
 	if len(*a.array) <= 1 {
 		return
 	}
@@ -194,28 +199,31 @@ func (a *array[T]) Sort(comparator func(a, b T) int) {
 	}
 }
 
-func (a *array[T]) Join(separator string) string {
+func (a *Array[T]) Join(separator string) string {
 	result := ""
 	for i, item := range *a.array {
 		if i > 0 {
 			result += separator
 		}
-		result += anyToString(item)
+		result += ToString(item)
 	}
 	return result
 }
 
-func anyToString(value any) string {
+// ToString formats an element the way Join does.
+func ToString(value any) string {
 	switch v := value.(type) {
 	case string:
 		return v
 	case int, int8, int16, int32, int64:
 		return fmt.Sprintf("%d", v)
-	case float32, float64:
-		return fmt.Sprintf("%f", v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
 	case bool:
 		return fmt.Sprintf("%t", v)
 	default:
-		return fmt.Sprintf("%v", v)
+		return object.Sprint(v)
 	}
 }
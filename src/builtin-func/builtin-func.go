@@ -2,27 +2,25 @@ package builtinfunc
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"strconv"
-	"strings"
 
 	"theparadance.com/quan-lang/src/env"
 	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
 	systemconsole "theparadance.com/quan-lang/src/system-console"
 )
 
 func BuildInFuncs(console systemconsole.SystemConsole) map[string]env.BuiltinFunc {
-	return map[string]env.BuiltinFunc{
+	funcs := map[string]env.BuiltinFunc{
 		"print": func(args []interface{}) (interface{}, error) {
 			for _, arg := range args {
 				switch v := arg.(type) {
-				case map[string]interface{}:
-					jsonBytes, err := json.Marshal(v)
+				case *object.Object:
+					jsonBytes, err := v.MarshalJSON()
 					if err != nil {
-						return nil, err
+						return nil, &errorexception.RuntimeError{Message: "print() " + err.Error()}
 					}
 					console.Println(string(jsonBytes))
 				default:
@@ -34,10 +32,10 @@ func BuildInFuncs(console systemconsole.SystemConsole) map[string]env.BuiltinFun
 		"println": func(args []interface{}) (interface{}, error) {
 			for _, arg := range args {
 				switch v := arg.(type) {
-				case map[string]interface{}:
-					jsonBytes, err := json.Marshal(v)
+				case *object.Object:
+					jsonBytes, err := v.MarshalJSON()
 					if err != nil {
-						return nil, err
+						return nil, &errorexception.RuntimeError{Message: "println() " + err.Error()}
 					}
 					console.Println(string(jsonBytes))
 				default:
@@ -56,50 +54,19 @@ func BuildInFuncs(console systemconsole.SystemConsole) map[string]env.BuiltinFun
 				return "string", nil
 			case bool:
 				return "bool", nil
-			case map[string]interface{}:
+			case *object.Object, *env.HostObject:
 				return "object", nil
-			case []interface{}:
+			case *object.Array:
 				return "array", nil
-			case nil:
+			case nil, *object.Null:
 				return "null", nil
+			case expression.FuncDef, *env.Closure, env.BuiltinFunc, env.Callable:
+				return "function", nil
 			default:
 				return "unknown", nil
 			}
 		},
-		"string": func(args []interface{}) (interface{}, error) {
-			if len(args) != 1 {
-				return "error: str() expects 1 argument", &errorexception.RuntimeError{
-					Message: "error: str() expects 1 argument",
-				}
-			}
-
-			switch v := args[0].(type) {
-			case int:
-				return fmt.Sprintf("%d", v), nil
-			case float64:
-				return fmt.Sprintf("%g", v), nil
-			case bool:
-				return fmt.Sprintf("%t", v), nil
-			case string:
-				return v, nil
-			case nil:
-				return "null", nil
-			case []interface{}:
-				elems := make([]string, len(v))
-				for i, e := range v {
-					elems[i] = fmt.Sprintf("%v", e)
-				}
-				return "[" + strings.Join(elems, ", ") + "]", nil
-			case map[string]interface{}:
-				pairs := []string{}
-				for key, val := range v {
-					pairs = append(pairs, fmt.Sprintf("%s: %v", key, val))
-				}
-				return "{" + strings.Join(pairs, ", ") + "}", nil
-			default:
-				return fmt.Sprintf("%v", v), nil
-			}
-		},
+		"string": stringFunc(nil),
 		"int": func(args []interface{}) (interface{}, error) {
 			if len(args) != 1 {
 				return "error: int() expects 1 argument", &errorexception.RuntimeError{
@@ -215,109 +182,65 @@ func BuildInFuncs(console systemconsole.SystemConsole) map[string]env.BuiltinFun
 				}
 			}
 		},
-		"fetch": func(args []interface{}) (interface{}, error) {
-			if len(args) != 1 {
-				return nil, &errorexception.RuntimeError{
-					Message: "fetch() expects 1 argument (a config object)",
-				}
-			}
-
-			// Expect argument to be a map (like a JS object)
-			config, ok := args[0].(map[string]interface{})
-			if !ok {
-				return nil, &errorexception.RuntimeError{
-					Message: "fetch() argument must be a map (object-like)",
-				}
-			}
-
-			// Extract URL
-			urlVal, ok := config["url"].(string)
-			if !ok || urlVal == "" {
-				return nil, &errorexception.RuntimeError{
-					Message: "fetch() requires a 'url' string field",
-				}
-			}
-
-			// Method (optional, default GET)
-			method := "GET"
-			if m, ok := config["method"].(string); ok && m != "" {
-				method = m
-			}
-
-			// Body (optional)
-			var bodyReader io.Reader
-			if body, ok := config["body"]; ok {
-				switch b := body.(type) {
-				case string:
-					bodyReader = strings.NewReader(b)
-				case []byte:
-					bodyReader = bytes.NewReader(b)
-				default:
-					return nil, &errorexception.RuntimeError{
-						Message: "fetch() 'body' must be a string or []byte",
-					}
-				}
-			}
-
-			// Build request
-			req, err := http.NewRequest(method, urlVal, bodyReader)
-			if err != nil {
-				return nil, &errorexception.RuntimeError{
-					Message: "fetch() failed to create request: " + err.Error(),
-				}
-			}
-
-			// Headers (optional)
-			if h, ok := config["headers"].(map[string]interface{}); ok {
-				for k, v := range h {
-					if valStr, ok := v.(string); ok {
-						req.Header.Set(k, valStr)
-					}
-				}
-			}
-
-			// Send request
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return nil, &errorexception.RuntimeError{
-					Message: "fetch() failed: " + err.Error(),
-				}
-			}
-			defer resp.Body.Close()
-
-			// Read response
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, &errorexception.RuntimeError{
-					Message: "fetch() failed reading response: " + err.Error(),
-				}
-			}
-
-			return string(bodyBytes), nil
-		},
-		"toMap": func(args []interface{}) (interface{}, error) {
-			if len(args) != 1 {
-				return nil, &errorexception.RuntimeError{
-					Message: "toJson() expects exactly 1 argument: (jsonString)",
-				}
-			}
-
-			jsonStr, ok := args[0].(string)
-			if !ok {
-				return nil, &errorexception.RuntimeError{
-					Message: "toJson() argument must be a JSON string",
-				}
-			}
+		"fetch": Fetch(nil),
+	}
 
-			var result interface{}
-			err := json.Unmarshal([]byte(jsonStr), &result)
-			if err != nil {
-				return nil, &errorexception.RuntimeError{
-					Message: "toJson() failed to parse JSON: " + err.Error(),
-				}
-			}
+	for name, fn := range objectFuncs() {
+		funcs[name] = fn
+	}
+	for name, fn := range jsonFuncs() {
+		funcs[name] = fn
+	}
+	return funcs
+}
 
-			return result, nil
-		},
+func stringFunc(limits *env.Limits) env.BuiltinFunc {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return "error: str() expects 1 argument", &errorexception.RuntimeError{
+				Message: "error: str() expects 1 argument",
+			}
+		}
+
+		switch v := args[0].(type) {
+		case int:
+			return fmt.Sprintf("%d", v), nil
+		case float64:
+			return fmt.Sprintf("%g", v), nil
+		case bool:
+			return fmt.Sprintf("%t", v), nil
+		case string:
+			return v, nil
+		case nil, *object.Null:
+			return "null", nil
+		case *object.Array:
+			var buf bytes.Buffer
+			printer := object.Printer{Reserve: limits.AllocBytes}
+			buf.WriteByte('[')
+			for i, e := range v.Items {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				printer.Print(&buf, e)
+			}
+			buf.WriteByte(']')
+			return buf.String(), nil
+		case *object.Object:
+			var buf bytes.Buffer
+			printer := object.Printer{Reserve: limits.AllocBytes}
+			buf.WriteByte('{')
+			for i, key := range v.Keys() {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				val, _ := v.GetProperty(key)
+				buf.WriteString(key + ": ")
+				printer.Print(&buf, val)
+			}
+			buf.WriteByte('}')
+			return buf.String(), nil
+		default:
+			return fmt.Sprintf("%v", v), nil
+		}
 	}
 }
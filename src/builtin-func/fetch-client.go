@@ -0,0 +1,34 @@
+//go:build !js
+
+package builtinfunc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// transport returns an HTTP transport that checks every address it connects
+// to against the policy.
+func (policy *FetchPolicy) transport() http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		// checked after DNS resolution, so a public name that resolves to
+		// a private address is denied as well
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return policy.checkIP(net.ParseIP(host))
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	return transport
+}
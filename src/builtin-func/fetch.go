@@ -0,0 +1,261 @@
+package builtinfunc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// DEFAULT_FETCH_TIMEOUT bounds requests that set no 'timeout' of their own.
+const DEFAULT_FETCH_TIMEOUT = 30 * time.Second
+
+// Fetch returns the fetch builtin sending its requests through transport,
+// for example a stand-in for tests. nil uses http.DefaultTransport.
+func Fetch(transport http.RoundTripper) env.BuiltinFunc {
+	return FetchWithLimits(transport, nil)
+}
+
+// FetchWithLimits is Fetch for one execution: a request still running when
+// the execution is cancelled or times out is aborted, and the call fails
+// with the LimitExceededError of limits.
+func FetchWithLimits(transport http.RoundTripper, limits *env.Limits) env.BuiltinFunc {
+	return fetchFunc(newClient(transport, nil), nil, limits)
+}
+
+// newClient returns the client fetch sends requests with. A non-nil policy
+// checks every redirect.
+func newClient(transport http.RoundTripper, policy *FetchPolicy) *http.Client {
+	client := &http.Client{Transport: transport}
+	if policy != nil {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return policy.checkRequest(req.Method, req.URL)
+		}
+	}
+	return client
+}
+
+// fetchFunc returns the fetch builtin sending requests with client under
+// the context of limits. A non-nil policy restricts the requests it may
+// send.
+//
+// The config object takes 'url', 'method', 'headers', 'query', 'body' and
+// 'timeout' (in milliseconds). The result is an object with 'status', 'ok',
+// 'headers', 'text' and 'json', the body parsed as JSON or null. A response
+// with an error status is returned like any other.
+func fetchFunc(client *http.Client, policy *FetchPolicy, limits *env.Limits) env.BuiltinFunc {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, &errorexception.RuntimeError{
+				Message: "fetch() expects 1 argument (a config object)",
+			}
+		}
+
+		// Expect argument to be a map (like a JS object)
+		config, ok := args[0].(*object.Object)
+		if !ok {
+			return nil, &errorexception.RuntimeError{
+				Message: "fetch() argument must be a map (object-like)",
+			}
+		}
+
+		// Extract URL
+		urlProp, _ := config.GetProperty("url")
+		urlVal, ok := urlProp.(string)
+		if !ok || urlVal == "" {
+			return nil, &errorexception.RuntimeError{
+				Message: "fetch() requires a 'url' string field",
+			}
+		}
+
+		// Method (optional, default GET)
+		method := "GET"
+		methodProp, _ := config.GetProperty("method")
+		if m, ok := methodProp.(string); ok && m != "" {
+			method = m
+		}
+
+		// Body (optional), anything but a string is sent as JSON
+		var bodyReader io.Reader
+		contentType := ""
+		if body, ok := config.GetProperty("body"); ok {
+			switch b := body.(type) {
+			case nil, *object.Null:
+			case string:
+				bodyReader = strings.NewReader(b)
+			case []byte:
+				bodyReader = bytes.NewReader(b)
+			default:
+				var buf bytes.Buffer
+				if err := writeJSON(&buf, b, limits); err != nil {
+					return nil, &errorexception.RuntimeError{
+						Message: "fetch() 'body' " + err.Error(),
+					}
+				}
+				bodyReader = &buf
+				contentType = "application/json"
+			}
+		}
+
+		timeout := DEFAULT_FETCH_TIMEOUT
+		if timeoutProp, ok := config.GetProperty("timeout"); ok {
+			ms, ok := toNumber(timeoutProp)
+			if !ok || ms <= 0 {
+				return nil, &errorexception.RuntimeError{
+					Message: "fetch() 'timeout' must be a positive number of milliseconds",
+				}
+			}
+			timeout = time.Duration(ms * float64(time.Millisecond))
+		}
+		ctx, cancel := context.WithTimeout(limits.Context(), timeout)
+		defer cancel()
+
+		// Build request
+		req, err := http.NewRequestWithContext(ctx, method, urlVal, bodyReader)
+		if err != nil {
+			return nil, &errorexception.RuntimeError{
+				Message: "fetch() failed to create request: " + err.Error(),
+			}
+		}
+		if queryProp, ok := config.GetProperty("query"); ok {
+			if err := setQuery(req.URL, queryProp); err != nil {
+				return nil, err
+			}
+		}
+		if err := policy.checkRequest(req.Method, req.URL); err != nil {
+			return nil, err
+		}
+
+		// Headers (optional)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		headersProp, _ := config.GetProperty("headers")
+		if h, ok := headersProp.(*object.Object); ok {
+			for _, k := range h.Keys() {
+				v, _ := h.GetProperty(k)
+				switch val := v.(type) {
+				case string:
+					req.Header.Set(k, val)
+				case int, float64, bool:
+					req.Header.Set(k, fmt.Sprint(val))
+				}
+			}
+		}
+
+		// Send request
+		resp, err := client.Do(req)
+		if err != nil {
+			if err := limits.Err(); err != nil {
+				return nil, err
+			}
+			var policyErr *errorexception.PolicyError
+			if errors.As(err, &policyErr) {
+				return nil, policyErr
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, &errorexception.RuntimeError{
+					Message: fmt.Sprintf("fetch() timed out after %s", timeout),
+				}
+			}
+			return nil, &errorexception.RuntimeError{
+				Message: "fetch() failed: " + err.Error(),
+			}
+		}
+		defer resp.Body.Close()
+
+		// Read response
+		bodyBytes, err := policy.readBody(resp.Body)
+		if err != nil {
+			if err := limits.Err(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		return response(resp, bodyBytes), nil
+	}
+}
+
+// response builds the object fetch returns.
+func response(resp *http.Response, body []byte) *object.Object {
+	// header names in sorted order, so the object prints the same every time
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := object.NewObject()
+	for _, name := range names {
+		headers.SetProperty(strings.ToLower(name), strings.Join(resp.Header[name], ", "))
+	}
+
+	var parsed interface{} = object.NULL
+	if val, err := object.ParseJSON(body); err == nil {
+		parsed = val
+	}
+
+	result := object.NewObject()
+	result.SetProperty("status", resp.StatusCode)
+	result.SetProperty("ok", resp.StatusCode >= 200 && resp.StatusCode < 300)
+	result.SetProperty("headers", headers)
+	result.SetProperty("text", string(body))
+	result.SetProperty("json", parsed)
+	return result
+}
+
+// setQuery adds the properties of a 'query' object to the query string of
+// u. Array values add the parameter once per element.
+func setQuery(u *url.URL, query interface{}) error {
+	obj, ok := query.(*object.Object)
+	if !ok {
+		return &errorexception.RuntimeError{
+			Message: "fetch() 'query' must be an object",
+		}
+	}
+	values := u.Query()
+	for _, key := range obj.Keys() {
+		val, _ := obj.GetProperty(key)
+		items := []interface{}{val}
+		if arr, ok := val.(*object.Array); ok {
+			items = arr.Items
+		}
+		for _, item := range items {
+			switch v := item.(type) {
+			case string:
+				values.Add(key, v)
+			case int, float64, bool:
+				values.Add(key, fmt.Sprint(v))
+			default:
+				return &errorexception.RuntimeError{
+					Message: fmt.Sprintf("fetch() query parameter %q must be a string, number or boolean", key),
+				}
+			}
+		}
+	}
+	u.RawQuery = values.Encode()
+	return nil
+}
+
+func toNumber(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,219 @@
+package builtinfunc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// config builds the object a script passes to fetch.
+func config(fields map[string]interface{}) *object.Object {
+	return object.FromGo(fields).(*object.Object)
+}
+
+func property(t *testing.T, val interface{}, path ...string) interface{} {
+	t.Helper()
+	for _, name := range path {
+		obj, ok := val.(*object.Object)
+		if !ok {
+			t.Fatalf("%v is not an object, looking up %q", val, name)
+		}
+		val, _ = obj.GetProperty(name)
+	}
+	return val
+}
+
+func TestFetchJSONBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Trace", "abc")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method":      r.Method,
+			"contentType": r.Header.Get("Content-Type"),
+			"token":       r.Header.Get("Authorization"),
+			"body":        string(body),
+		})
+	}))
+	defer server.Close()
+
+	fetch := builtinfunc.Fetch(server.Client().Transport)
+	result, err := fetch([]interface{}{config(map[string]interface{}{
+		"url":     server.URL,
+		"method":  "POST",
+		"headers": map[string]interface{}{"Authorization": "Bearer t"},
+		"body":    map[string]interface{}{"name": "Ann", "tags": []interface{}{"a", 1}},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path []string
+		want interface{}
+	}{
+		{[]string{"status"}, 201},
+		{[]string{"ok"}, true},
+		{[]string{"headers", "x-trace"}, "abc"},
+		{[]string{"json", "method"}, "POST"},
+		{[]string{"json", "contentType"}, "application/json"},
+		{[]string{"json", "token"}, "Bearer t"},
+		{[]string{"json", "body"}, `{"name":"Ann","tags":["a",1]}`},
+	} {
+		if got := property(t, result, tc.path...); got != tc.want {
+			t.Errorf("%s = %#v, want %#v", strings.Join(tc.path, "."), got, tc.want)
+		}
+	}
+	if text, _ := property(t, result, "text").(string); !strings.HasPrefix(text, `{"body":`) {
+		t.Errorf("text = %q, want the raw body", text)
+	}
+}
+
+func TestFetchNotJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "missing", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	result, err := builtinfunc.Fetch(server.Client().Transport)([]interface{}{
+		config(map[string]interface{}{"url": server.URL}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := property(t, result, "status"); got != 404 {
+		t.Errorf("status = %v, want 404", got)
+	}
+	if got := property(t, result, "ok"); got != false {
+		t.Errorf("ok = %v, want false", got)
+	}
+	if got := property(t, result, "json"); got != object.NULL {
+		t.Errorf("json = %v, want null", got)
+	}
+}
+
+func TestFetchQuery(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	fetch := builtinfunc.Fetch(server.Client().Transport)
+	for _, tc := range []struct {
+		name  string
+		url   string
+		query map[string]interface{}
+		want  string
+	}{
+		{"values", "", map[string]interface{}{"q": "a b", "n": 2, "on": true}, "n=2&on=true&q=a+b"},
+		{"repeated", "", map[string]interface{}{"id": []interface{}{1, 2}}, "id=1&id=2"},
+		{"merged with the url", "?page=3", map[string]interface{}{"q": "x"}, "page=3&q=x"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := fetch([]interface{}{config(map[string]interface{}{
+				"url":   server.URL + tc.url,
+				"query": tc.query,
+			})})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tc.want {
+				t.Errorf("query = %q, want %q", query, tc.want)
+			}
+		})
+	}
+
+	_, err := fetch([]interface{}{config(map[string]interface{}{
+		"url":   server.URL,
+		"query": map[string]interface{}{"bad": map[string]interface{}{}},
+	})})
+	if err == nil || !strings.Contains(err.Error(), `query parameter "bad"`) {
+		t.Errorf("err = %v, want a query parameter error", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	_, err := builtinfunc.Fetch(server.Client().Transport)([]interface{}{
+		config(map[string]interface{}{"url": server.URL, "timeout": 50}),
+	})
+	var runtimeErr *errorexception.RuntimeError
+	if !errors.As(err, &runtimeErr) || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fetch returned after %s", elapsed)
+	}
+
+	_, err = builtinfunc.Fetch(nil)([]interface{}{
+		config(map[string]interface{}{"url": server.URL, "timeout": -1}),
+	})
+	if err == nil || !strings.Contains(err.Error(), "'timeout'") {
+		t.Errorf("err = %v, want an invalid timeout", err)
+	}
+}
+
+func TestFetchPolicyRedirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same-host":
+			http.Redirect(w, r, "/done", http.StatusFound)
+		case "/other-host":
+			// the same server under a name the policy does not allow
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/done", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Write([]byte("done"))
+		}
+	}))
+	defer server.Close()
+
+	policy := &builtinfunc.Policy{
+		Fetch: builtinfunc.FetchPolicy{
+			AllowedHosts:         []string{"127.0.0.1"},
+			AllowPrivateNetworks: true,
+		},
+	}
+	builtins := map[string]env.BuiltinFunc{"fetch": builtinfunc.Fetch(nil)}
+	fetch := policy.Apply(builtins, nil, nil)["fetch"]
+
+	result, err := fetch([]interface{}{config(map[string]interface{}{"url": server.URL + "/same-host"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := property(t, result, "text"); got != "done" {
+		t.Errorf("text = %v, want done", got)
+	}
+
+	_, err = fetch([]interface{}{config(map[string]interface{}{"url": server.URL + "/other-host"})})
+	var policyErr *errorexception.PolicyError
+	if !errors.As(err, &policyErr) || !strings.Contains(err.Error(), "localhost") {
+		t.Errorf("err = %v, want a PolicyError for the redirect", err)
+	}
+
+	_, err = fetch([]interface{}{config(map[string]interface{}{"url": server.URL + "/loop"})})
+	if err == nil || !strings.Contains(err.Error(), "stopped after 10 redirects") {
+		t.Errorf("err = %v, want the redirect limit", err)
+	}
+}
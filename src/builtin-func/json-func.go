@@ -0,0 +1,174 @@
+package builtinfunc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+func jsonFuncs() map[string]env.BuiltinFunc {
+	return map[string]env.BuiltinFunc{
+		"toJson": toJSONFunc(nil),
+		"toMap": func(args []interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, &errorexception.RuntimeError{
+					Message: "toMap() expects exactly 1 argument: (jsonString)",
+				}
+			}
+
+			jsonStr, ok := args[0].(string)
+			if !ok {
+				return nil, &errorexception.RuntimeError{
+					Message: "toMap() argument must be a JSON string",
+				}
+			}
+
+			result, err := object.ParseJSON([]byte(jsonStr))
+			if err != nil {
+				return nil, &errorexception.RuntimeError{
+					Message: "toMap() failed to parse JSON: " + err.Error(),
+				}
+			}
+
+			return result, nil
+		},
+	}
+}
+
+// ConversionsWithLimits returns the toJson and string builtins for one
+// execution: the text they build is charged to the memory quota of limits as
+// it grows, so that a value far too large to print fails before its text has
+// been built.
+func ConversionsWithLimits(limits *env.Limits) map[string]env.BuiltinFunc {
+	return map[string]env.BuiltinFunc{
+		"toJson": toJSONFunc(limits),
+		"string": stringFunc(limits),
+	}
+}
+
+func toJSONFunc(limits *env.Limits) env.BuiltinFunc {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, &errorexception.RuntimeError{
+				Message: "toJson() expects 1 or 2 arguments: (value, indent?)",
+			}
+		}
+
+		indent := ""
+		if len(args) == 2 {
+			switch v := args[1].(type) {
+			case int:
+				indent = strings.Repeat(" ", v)
+			case float64:
+				indent = strings.Repeat(" ", int(v))
+			case string:
+				indent = v
+			default:
+				return nil, &errorexception.RuntimeError{
+					Message: "toJson() indent must be a number of spaces or a string",
+				}
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := writeJSON(&buf, args[0], limits); err != nil {
+			return nil, &errorexception.RuntimeError{
+				Message: "toJson() " + err.Error(),
+			}
+		}
+		if indent == "" {
+			return buf.String(), nil
+		}
+
+		limits.AllocBytes(indentedSize(buf.Bytes(), indent))
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, buf.Bytes(), "", indent); err != nil {
+			return nil, &errorexception.RuntimeError{
+				Message: "toJson() " + err.Error(),
+			}
+		}
+		return indented.String(), nil
+	}
+}
+
+// indentedSize returns the length of the compact JSON data once json.Indent
+// has indented it, so that the indented text can be reserved before it is
+// built.
+func indentedSize(data []byte, indent string) int64 {
+	size, depth := int64(len(data)), int64(0)
+	newline := func() {
+		size = object.AddSize(size, object.AddSize(1, object.MulSize(int64(len(indent)), depth)))
+	}
+	inString, escaped := false, false
+	for i, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[', '{':
+			// empty arrays and objects stay on one line
+			if i+1 < len(data) && (data[i+1] == ']' || data[i+1] == '}') {
+				continue
+			}
+			depth++
+			newline()
+		case ']', '}':
+			if i > 0 && (data[i-1] == '[' || data[i-1] == '{') {
+				continue
+			}
+			depth--
+			newline()
+		case ',':
+			newline()
+		case ':':
+			size = object.AddSize(size, 1)
+		}
+	}
+	return size
+}
+
+// writeJSON serialises a runtime value as compact JSON. Object keys keep
+// their insertion order; cyclic values, functions and other host values are
+// rejected. The output is charged to limits as it grows.
+func writeJSON(buf *bytes.Buffer, val interface{}, limits *env.Limits) error {
+	writer := object.JSONWriter{
+		Reserve: limits.AllocBytes,
+		Other: func(buf *bytes.Buffer, val interface{}) error {
+			if host, ok := val.(*env.HostObject); ok {
+				data, err := host.MarshalJSON()
+				if err != nil {
+					return err
+				}
+				buf.Write(data)
+				return nil
+			}
+			return fmt.Errorf("cannot serialise a value of type %s", typeName(val))
+		},
+	}
+	return writer.Write(buf, val)
+}
+
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case expression.FuncDef, *env.Closure, env.BuiltinFunc, env.Callable:
+		return "function"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
@@ -0,0 +1,171 @@
+package builtinfunc
+
+import (
+	"fmt"
+
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// objectFuncs are the builtins for working with objects whose keys are only
+// known at runtime. Keys are visited in insertion order.
+func objectFuncs() map[string]env.BuiltinFunc {
+	return map[string]env.BuiltinFunc{
+		"keys": func(args []interface{}) (interface{}, error) {
+			obj, err := propertiesArg("keys", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			result := []interface{}{}
+			for _, key := range obj.Keys() {
+				result = append(result, key)
+			}
+			return result, nil
+		},
+		"values": func(args []interface{}) (interface{}, error) {
+			obj, err := propertiesArg("values", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			result := []interface{}{}
+			for _, key := range obj.Keys() {
+				val, _ := obj.GetProperty(key)
+				result = append(result, val)
+			}
+			return result, nil
+		},
+		"entries": func(args []interface{}) (interface{}, error) {
+			obj, err := propertiesArg("entries", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			result := []interface{}{}
+			for _, key := range obj.Keys() {
+				val, _ := obj.GetProperty(key)
+				result = append(result, []interface{}{key, val})
+			}
+			return result, nil
+		},
+		"has": func(args []interface{}) (interface{}, error) {
+			obj, err := propertiesArg("has", args, 2)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := args[1].(string)
+			if !ok {
+				return nil, &errorexception.RuntimeError{
+					Message: "has() key must be a string",
+				}
+			}
+			_, has := obj.GetProperty(key)
+			return has, nil
+		},
+		"delete": func(args []interface{}) (interface{}, error) {
+			obj, err := objectArg("delete", args, 2)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := args[1].(string)
+			if !ok {
+				return nil, &errorexception.RuntimeError{
+					Message: "delete() key must be a string",
+				}
+			}
+			return obj.DeleteProperty(key), nil
+		},
+		"merge": func(args []interface{}) (interface{}, error) {
+			return mergeObjects("merge", args, false)
+		},
+		"deepMerge": func(args []interface{}) (interface{}, error) {
+			return mergeObjects("deepMerge", args, true)
+		},
+	}
+}
+
+func objectArg(name string, args []interface{}, count int) (*object.Object, error) {
+	if len(args) != count {
+		return nil, &errorexception.RuntimeError{
+			Message: fmt.Sprintf("%s() expects %d argument(s)", name, count),
+		}
+	}
+	obj, ok := args[0].(*object.Object)
+	if !ok {
+		return nil, &errorexception.RuntimeError{
+			Message: name + "() first argument must be an object",
+		}
+	}
+	return obj, nil
+}
+
+// propertiesArg is objectArg for builtins that only read the object, which
+// may also be a bound Go struct.
+func propertiesArg(name string, args []interface{}, count int) (object.Properties, error) {
+	if len(args) != count {
+		return nil, &errorexception.RuntimeError{
+			Message: fmt.Sprintf("%s() expects %d argument(s)", name, count),
+		}
+	}
+	obj, ok := args[0].(object.Properties)
+	if !ok {
+		return nil, &errorexception.RuntimeError{
+			Message: name + "() first argument must be an object",
+		}
+	}
+	return obj, nil
+}
+
+// mergeObjects returns a new object with the keys of every argument, later
+// arguments winning. A deep merge combines nested objects instead of
+// replacing them, and fails on an object that contains itself.
+func mergeObjects(name string, args []interface{}, deep bool) (interface{}, error) {
+	result := object.NewObject()
+	active := make(map[*object.Object]bool)
+	for _, arg := range args {
+		obj, ok := arg.(*object.Object)
+		if !ok {
+			return nil, &errorexception.RuntimeError{
+				Message: name + "() arguments must be objects",
+			}
+		}
+		if err := mergeInto(name, result, obj, deep, active); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// mergeInto copies the keys of source into target. active holds the
+// objects a deep merge is inside of, so a cycle is reported instead of
+// recursing until the Go stack overflows.
+func mergeInto(name string, target, source *object.Object, deep bool, active map[*object.Object]bool) error {
+	if active[source] {
+		return &errorexception.RuntimeError{
+			Message: name + "() cannot merge an object that contains itself",
+		}
+	}
+	active[source] = true
+	defer delete(active, source)
+
+	for _, key := range source.Keys() {
+		val, _ := source.GetProperty(key)
+		if deep {
+			if srcObj, ok := val.(*object.Object); ok {
+				merged := object.NewObject()
+				existing, _ := target.GetProperty(key)
+				if dstObj, ok := existing.(*object.Object); ok {
+					if err := mergeInto(name, merged, dstObj, true, active); err != nil {
+						return err
+					}
+				}
+				if err := mergeInto(name, merged, srcObj, true, active); err != nil {
+					return err
+				}
+				target.SetProperty(key, merged)
+				continue
+			}
+		}
+		target.SetProperty(key, val)
+	}
+	return nil
+}
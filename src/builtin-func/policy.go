@@ -0,0 +1,207 @@
+package builtinfunc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+)
+
+// Policy restricts what the builtins of an execution may do. Violations are
+// reported as *errorexception.PolicyError. One Policy may be shared by many
+// executions; it must not be copied once used.
+type Policy struct {
+	// Builtins lists the builtins scripts may call. nil allows every
+	// builtin; calling one that is not listed fails with a PolicyError.
+	Builtins []string
+	// Fetch restricts the requests of fetch().
+	Fetch FetchPolicy
+
+	transportOnce sync.Once
+	transport     http.RoundTripper // built by the first Apply without one
+}
+
+// FetchPolicy restricts the requests fetch() may send. The zero value
+// allows any method over http and https to any public host.
+type FetchPolicy struct {
+	// AllowedHosts lists the hosts that may be requested, e.g.
+	// "api.example.com" or "*.example.com" for its subdomains. Empty
+	// allows every host.
+	AllowedHosts []string
+	// AllowedMethods lists the HTTP methods that may be used. Empty
+	// allows every method.
+	AllowedMethods []string
+	// AllowedSchemes lists the URL schemes that may be used. Empty allows
+	// "http" and "https".
+	AllowedSchemes []string
+	// MaxResponseBytes fails requests whose response body is larger. 0
+	// means no limit.
+	MaxResponseBytes int64
+	// AllowPrivateNetworks permits requests to loopback, private,
+	// link-local and other non-public addresses, which are denied by
+	// default so scripts cannot reach internal services.
+	AllowPrivateNetworks bool
+}
+
+// Apply returns the builtins that replace the given ones under the policy:
+// builtins the policy does not allow fail when called, and fetch enforces
+// the fetch policy. Put them in a scope above the host's builtins.
+//
+// fetch sends its requests through transport, or when it is nil through one
+// that checks every address it connects to, built once for the policy so
+// that executions sharing it share its connections. An injected transport is
+// trusted to reach only what it should; the URL is still checked. Requests
+// are aborted when the execution of limits stops, see FetchWithLimits.
+func (policy *Policy) Apply(builtins map[string]env.BuiltinFunc, transport http.RoundTripper, limits *env.Limits) map[string]env.BuiltinFunc {
+	allowed := make(map[string]bool, len(policy.Builtins))
+	for _, name := range policy.Builtins {
+		allowed[name] = true
+	}
+
+	result := make(map[string]env.BuiltinFunc)
+	for name := range builtins {
+		if policy.Builtins != nil && !allowed[name] {
+			result[name] = deniedBuiltin(name)
+		} else if name == "fetch" {
+			if transport == nil {
+				policy.transportOnce.Do(func() {
+					policy.transport = policy.Fetch.transport()
+				})
+				transport = policy.transport
+			}
+			result[name] = fetchFunc(newClient(transport, &policy.Fetch), &policy.Fetch, limits)
+		}
+	}
+	return result
+}
+
+func deniedBuiltin(name string) env.BuiltinFunc {
+	return func(args []interface{}) (interface{}, error) {
+		return nil, &errorexception.PolicyError{
+			Message: fmt.Sprintf("%s() is not allowed by the execution policy", name),
+		}
+	}
+}
+
+// checkRequest reports whether a request may be sent. A nil policy allows
+// everything.
+func (policy *FetchPolicy) checkRequest(method string, u *url.URL) error {
+	if policy == nil {
+		return nil
+	}
+	schemes := policy.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return policyError("fetch() scheme %q is not allowed", u.Scheme)
+	}
+	if len(policy.AllowedMethods) > 0 && !containsFold(policy.AllowedMethods, method) {
+		return policyError("fetch() method %s is not allowed", method)
+	}
+	host := u.Hostname()
+	if len(policy.AllowedHosts) > 0 && !hostAllowed(policy.AllowedHosts, host) {
+		return policyError("fetch() host %q is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return policy.checkIP(ip)
+	}
+	if !policy.AllowPrivateNetworks && strings.EqualFold(host, "localhost") {
+		return policyError("fetch() to private address %s is not allowed", host)
+	}
+	return nil
+}
+
+func (policy *FetchPolicy) checkIP(ip net.IP) error {
+	if ip == nil || policy.AllowPrivateNetworks || isPublicIP(ip) {
+		return nil
+	}
+	return policyError("fetch() to private address %s is not allowed", ip)
+}
+
+// readBody reads a response body, failing if it is larger than the policy
+// allows.
+func (policy *FetchPolicy) readBody(body io.Reader) ([]byte, error) {
+	limit := int64(0)
+	if policy != nil {
+		limit = policy.MaxResponseBytes
+	}
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, &errorexception.RuntimeError{
+			Message: "fetch() failed reading response: " + err.Error(),
+		}
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, policyError("fetch() response is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// nonPublicNetworks are ranges net.IP has no predicate for.
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	mustParseCIDR("64:ff9b::/96"),  // NAT64, may map to private IPv4
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func hostAllowed(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func policyError(format string, args ...interface{}) error {
+	return &errorexception.PolicyError{Message: fmt.Sprintf(format, args...)}
+}
@@ -2,37 +2,171 @@ package env
 
 import (
 	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/module"
+	"theparadance.com/quan-lang/src/object"
 )
 
 type BuiltinFunc func(args []any) (any, error)
 
+// Closure is a function bound to the scope it was defined in. Functions
+// exported from a module are closures over the module scope, so they keep
+// seeing the module's variables when called from the importer.
+type Closure struct {
+	Func expression.FuncDef
+	Env  *Env
+}
+
+// Callable is implemented by function values that are not expression.FuncDef,
+// such as functions compiled to bytecode. They can end up anywhere a value
+// can, e.g. in the variables of a finished execution, so the interpreter
+// calls them through their definition.
+type Callable interface {
+	ParamCount() int
+	Definition() expression.FuncDef
+}
+
+// Unset fills the slots of a frame whose variables have not been assigned.
+var Unset = &unset{}
+
+type unset struct{}
+
 type Env struct {
 	Vars    map[string]interface{}
 	Funcs   map[string]expression.FuncDef
 	Builtin map[string]BuiltinFunc
 	Parent  *Env
+
+	// Frames of resolved functions keep their locals in slots.
+	Scope *expression.Scope
+	Slots []interface{}
+
+	// Set on the top-level scope of a program or module.
+	Modules    *module.Registry
+	ModuleName string
+	Exports    []string
+
+	// Limits of the running execution, shared with every nested scope.
+	// nil means unlimited.
+	Limits *Limits
+
+	// Set by Freeze.
+	frozen bool
 }
 
 func NewEnv(parent *Env) *Env {
-	return &Env{
+	env := &Env{
 		Vars:   make(map[string]interface{}),
 		Funcs:  make(map[string]expression.FuncDef),
 		Parent: parent,
 	}
+	if parent != nil {
+		env.Limits = parent.Limits
+	}
+	return env
+}
+
+// NewFrame creates the scope of a call to a resolved function. The maps are
+// only allocated if something is stored by name outside of the slots.
+func NewFrame(parent *Env, scope *expression.Scope) *Env {
+	slots := make([]interface{}, len(scope.Names))
+	for i := range slots {
+		slots[i] = Unset
+	}
+	return &Env{
+		Parent: parent,
+		Scope:  scope,
+		Slots:  slots,
+		Limits: parent.Limits,
+	}
+}
+
+// slot returns the index of name in the frame's slots, or -1.
+func (env *Env) slot(name string) int {
+	if env.Scope != nil {
+		if slot, ok := env.Scope.Slots[name]; ok {
+			return slot
+		}
+	}
+	return -1
 }
 
 func (env *Env) GetVar(name string) (interface{}, bool) {
+	if slot := env.slot(name); slot >= 0 && env.Slots[slot] != Unset {
+		return env.Slots[slot], true
+	}
 	val, ok := env.Vars[name]
 	if !ok && env.Parent != nil {
+		if env.Parent.frozen && !env.frozen {
+			return env.copyFromFrozen(name)
+		}
 		return env.Parent.GetVar(name)
 	}
 	return val, ok
 }
 
+// copyFromFrozen reads name from the frozen scopes above env. Arrays and
+// objects are copied into env on first read, so the changes an execution
+// makes to them stay in its own scope.
+func (env *Env) copyFromFrozen(name string) (interface{}, bool) {
+	val, ok := env.Parent.GetVar(name)
+	if !ok {
+		return nil, false
+	}
+	switch val.(type) {
+	case *object.Array, *object.Object:
+		val = deepCopy(val, make(map[interface{}]interface{}))
+		env.Limits.AllocDeep(val)
+		env.SetVar(name, val)
+	}
+	return val, true
+}
+
 func (env *Env) SetVar(name string, val interface{}) {
+	env.checkWritable()
+	if slot := env.slot(name); slot >= 0 {
+		env.Slots[slot] = val
+		return
+	}
+	if env.Vars == nil {
+		env.Vars = make(map[string]interface{})
+	}
 	env.Vars[name] = val
 }
 
+// UpdateVar overwrites an existing variable in the scope that defines it and
+// reports whether the variable was found.
+func (env *Env) UpdateVar(name string, val interface{}) bool {
+	if slot := env.slot(name); slot >= 0 && env.Slots[slot] != Unset {
+		env.Slots[slot] = val
+		return true
+	}
+	if _, ok := env.Vars[name]; ok {
+		env.checkWritable()
+		env.Vars[name] = val
+		return true
+	}
+	if env.Parent != nil {
+		if env.Parent.frozen && !env.frozen {
+			// shadow the frozen variable instead of changing it
+			if _, ok := env.Parent.GetVar(name); !ok {
+				return false
+			}
+			env.SetVar(name, val)
+			return true
+		}
+		return env.Parent.UpdateVar(name, val)
+	}
+	return false
+}
+
+func (env *Env) SetFunc(name string, fn expression.FuncDef) {
+	env.checkWritable()
+	if env.Funcs == nil {
+		env.Funcs = make(map[string]expression.FuncDef)
+	}
+	env.Funcs[name] = fn
+}
+
 func (env *Env) GetFunc(name string) (expression.FuncDef, bool) {
 	fn, ok := env.Funcs[name]
 	if !ok && env.Parent != nil {
@@ -48,3 +182,14 @@ func (env *Env) GetBuiltin(name string) (BuiltinFunc, bool) {
 	}
 	return fn, ok
 }
+
+// ModuleScope returns the nearest enclosing top-level scope of a program or
+// module, or nil when modules are not enabled.
+func (env *Env) ModuleScope() *Env {
+	for scope := env; scope != nil; scope = scope.Parent {
+		if scope.Modules != nil {
+			return scope
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package env
+
+import (
+	"theparadance.com/quan-lang/src/object"
+)
+
+// Freeze makes env and its parents immutable, so that one prepared
+// environment can serve many goroutines at once. Run every execution in a
+// child of the frozen env, as lang.Execuate does: the child reads the
+// frozen variables, functions and builtins; assignments to frozen
+// variables create a variable in the child instead, and arrays and objects
+// are copied into the child the first time it reads them, so changes never
+// reach the frozen env or other executions. Go structs are exposed
+// read-only. Freeze converts host values like lang.Execuate and returns env.
+//
+// Changing a frozen env panics. Builtins run concurrently and must be safe
+// for that; lang.Execuate gives every execution its own print and println.
+func (env *Env) Freeze() *Env {
+	for scope := env; scope != nil && !scope.frozen; scope = scope.Parent {
+		for name, val := range scope.Vars {
+			scope.Vars[name] = freezeValue(FromHost(val))
+		}
+		scope.frozen = true
+	}
+	return env
+}
+
+// Frozen reports whether env was frozen by Freeze.
+func (env *Env) Frozen() bool {
+	return env.frozen
+}
+
+func (env *Env) checkWritable() {
+	if env.frozen {
+		panic("Cannot modify a frozen environment")
+	}
+}
+
+// freezeValue makes the Go structs in val read-only.
+func freezeValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case *HostObject:
+		if !v.readOnly {
+			return &HostObject{value: v.value, readOnly: true}
+		}
+	case *object.Array:
+		for i, item := range v.Items {
+			v.Items[i] = freezeValue(item)
+		}
+	case *object.Object:
+		for _, key := range v.Keys() {
+			item, _ := v.GetProperty(key)
+			v.SetProperty(key, freezeValue(item))
+		}
+	}
+	return val
+}
+
+// deepCopy copies the arrays and objects in val. Arrays and objects
+// reachable more than once are copied once.
+func deepCopy(val interface{}, copies map[interface{}]interface{}) interface{} {
+	switch v := val.(type) {
+	case *object.Array:
+		if copied, ok := copies[v]; ok {
+			return copied
+		}
+		result := object.NewArray(make([]interface{}, v.Len()))
+		copies[v] = result
+		for i, item := range v.Items {
+			result.Items[i] = deepCopy(item, copies)
+		}
+		return result
+	case *object.Object:
+		if copied, ok := copies[v]; ok {
+			return copied
+		}
+		result := object.NewObject()
+		copies[v] = result
+		for _, key := range v.Keys() {
+			item, _ := v.GetProperty(key)
+			result.SetProperty(key, deepCopy(item, copies))
+		}
+		return result
+	default:
+		return val
+	}
+}
@@ -0,0 +1,213 @@
+package env
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// HostObject exposes a Go struct to scripts without copying it into an
+// object. Scripts read its exported fields, named by their `json` tags,
+// call its exported methods and, unless it is read-only, assign its fields.
+// Nested structs are exposed the same way; slices and maps are copied into
+// arrays and objects when read.
+type HostObject struct {
+	value    reflect.Value // the struct, addressable
+	readOnly bool
+}
+
+// Bind exposes val, a struct or a pointer to a struct, to scripts. Through
+// a pointer scripts change the host's struct; a struct value is copied
+// first. Structs in env.Vars are bound automatically.
+func Bind(val any) *HostObject {
+	return bind(val, false)
+}
+
+// BindReadOnly is Bind for a struct scripts may read but not change: field
+// assignments fail and only methods with a value receiver can be called.
+func BindReadOnly(val any) *HostObject {
+	return bind(val, true)
+}
+
+func bind(val any, readOnly bool) *HostObject {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct {
+		return &HostObject{value: rv.Elem(), readOnly: readOnly}
+	}
+	if rv.Kind() == reflect.Struct {
+		return newHostObject(rv, readOnly)
+	}
+	panic(fmt.Sprintf("env.Bind: expected a struct or a pointer to a struct, got %T", val))
+}
+
+// newHostObject exposes the struct val, copying it unless it is addressable.
+func newHostObject(val reflect.Value, readOnly bool) *HostObject {
+	if !val.CanAddr() {
+		copied := reflect.New(val.Type()).Elem()
+		copied.Set(val)
+		val = copied
+	}
+	return &HostObject{value: val, readOnly: readOnly}
+}
+
+// Value returns a pointer to the bound struct.
+func (host *HostObject) Value() any {
+	return host.value.Addr().Interface()
+}
+
+// ReadOnly reports whether scripts may only read the struct.
+func (host *HostObject) ReadOnly() bool {
+	return host.readOnly
+}
+
+// Keys returns the names of the fields scripts can see, in declaration
+// order.
+func (host *HostObject) Keys() []string {
+	return fieldsOf(host.value.Type()).names
+}
+
+// GetProperty returns the field or the method named name.
+func (host *HostObject) GetProperty(name string) (object.Value, bool) {
+	if index, ok := fieldsOf(host.value.Type()).index[name]; ok {
+		field, err := host.value.FieldByIndexErr(index)
+		if err != nil {
+			// promoted through a nil embedded pointer
+			return object.NULL, true
+		}
+		return toScript(field, host.readOnly), true
+	}
+
+	receiver := host.value
+	if !host.readOnly {
+		receiver = receiver.Addr()
+	}
+	method := receiver.MethodByName(name)
+	if !method.IsValid() {
+		if host.readOnly && host.value.Addr().MethodByName(name).IsValid() {
+			panic(&errorexception.RuntimeError{
+				Message: fmt.Sprintf("Cannot call %s.%s: %s is read-only", host.typeName(), name, host.typeName()),
+			})
+		}
+		return nil, false
+	}
+	fn, err := NewFunc(name, method.Interface())
+	if err != nil {
+		panic(&errorexception.RuntimeError{
+			Message: fmt.Sprintf("%s.%s cannot be called from scripts: %s", host.typeName(), name, err.Error()),
+		})
+	}
+	return fn, true
+}
+
+// SetProperty assigns the field named name, converting val to its type.
+func (host *HostObject) SetProperty(name string, val any) error {
+	if host.readOnly {
+		return &errorexception.RuntimeError{
+			Message: fmt.Sprintf("Cannot assign to '%s': %s is read-only", name, host.typeName()),
+		}
+	}
+	index, ok := fieldsOf(host.value.Type()).index[name]
+	if !ok {
+		return &errorexception.RuntimeError{
+			Message: fmt.Sprintf("Cannot assign to '%s': %s has no such field", name, host.typeName()),
+		}
+	}
+	field, err := host.value.FieldByIndexErr(index)
+	if err != nil {
+		return &errorexception.RuntimeError{
+			Message: fmt.Sprintf("Cannot assign to '%s': %s", name, err.Error()),
+		}
+	}
+	converted, err := fromScript(val, field.Type(), host.typeName()+"."+name)
+	if err != nil {
+		return &errorexception.RuntimeError{Message: err.Error()}
+	}
+	field.Set(converted)
+	return nil
+}
+
+func (host *HostObject) MarshalJSON() ([]byte, error) {
+	return json.Marshal(host.value.Interface())
+}
+
+func (host *HostObject) String() string {
+	data, err := host.MarshalJSON()
+	if err != nil {
+		return "<" + host.typeName() + ">"
+	}
+	return string(data)
+}
+
+func (host *HostObject) typeName() string {
+	return host.value.Type().Name()
+}
+
+// structFields lists the fields of a struct type visible to scripts.
+type structFields struct {
+	names []string
+	index map[string][]int
+}
+
+var fieldCache sync.Map // reflect.Type -> *structFields
+
+func fieldsOf(t reflect.Type) *structFields {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(*structFields)
+	}
+	fields := &structFields{index: make(map[string][]int)}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || (field.Anonymous && indirect(field.Type).Kind() == reflect.Struct) {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		if _, ok := fields.index[name]; ok {
+			continue
+		}
+		fields.names = append(fields.names, name)
+		fields.index[name] = field.Index
+	}
+	cached, _ := fieldCache.LoadOrStore(t, fields)
+	return cached.(*structFields)
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// FromHost converts a value supplied by the host into a runtime value, like
+// object.FromGo, exposing structs with Bind.
+func FromHost(val any) any {
+	if val == nil {
+		return object.NULL
+	}
+	return toScript(reflect.ValueOf(val), false)
+}
+
+// isRuntimeValue reports whether val is already a value of the language,
+// which toScript passes on unchanged.
+func isRuntimeValue(val any) bool {
+	switch val.(type) {
+	case *object.Array, *object.Object, *object.Null, *HostObject, expression.FuncDef, *Closure, BuiltinFunc, Callable:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,159 @@
+package env
+
+import (
+	"context"
+	"fmt"
+
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// checkInterval is the number of steps between two checks of the context.
+const checkInterval = 1024
+
+// Limits bounds the work of one execution. It is shared by every scope of
+// the execution and is not safe for concurrent use. A limit of zero means
+// no limit.
+type Limits struct {
+	ctx            context.Context
+	maxSteps       int
+	maxCallDepth   int
+	maxMemoryBytes int64
+
+	steps  int
+	next   int // step at which the next checkpoint runs
+	depth  int
+	memory int64
+}
+
+// NewLimits creates the limits of an execution that stops when ctx is done,
+// after maxSteps evaluation steps, when calls nest deeper than maxCallDepth
+// or when it has allocated more than maxMemoryBytes.
+func NewLimits(ctx context.Context, maxSteps int, maxCallDepth int, maxMemoryBytes int64) *Limits {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// the first step checks the context, in case it is already done
+	return &Limits{
+		ctx:            ctx,
+		maxSteps:       maxSteps,
+		maxCallDepth:   maxCallDepth,
+		maxMemoryBytes: maxMemoryBytes,
+		next:           1,
+	}
+}
+
+// Step counts one evaluation step. It panics with a LimitExceededError when
+// the step budget is spent or the context is done.
+func (limits *Limits) Step() {
+	limits.steps++
+	if limits.steps >= limits.next {
+		limits.checkpoint()
+	}
+}
+
+func (limits *Limits) checkpoint() {
+	if limits.maxSteps > 0 && limits.steps > limits.maxSteps {
+		panic(&errorexception.LimitExceededError{
+			Message: fmt.Sprintf("Step limit of %d exceeded", limits.maxSteps),
+			Limit:   "steps",
+		})
+	}
+	if err := limits.Err(); err != nil {
+		panic(err)
+	}
+	limits.next = limits.steps + checkInterval
+	if limits.maxSteps > 0 && limits.next > limits.maxSteps+1 {
+		limits.next = limits.maxSteps + 1
+	}
+}
+
+// Context returns the context the execution stops with. Builtins that wait,
+// such as fetch, pass it on so that they stop waiting as well. It is
+// context.Background() for nil Limits.
+func (limits *Limits) Context() context.Context {
+	if limits == nil {
+		return context.Background()
+	}
+	return limits.ctx
+}
+
+// Err returns the LimitExceededError the execution stops with once its
+// context is done, and nil before.
+func (limits *Limits) Err() error {
+	err := limits.Context().Err()
+	if err == nil {
+		return nil
+	}
+	message := "Execution cancelled"
+	if err == context.DeadlineExceeded {
+		message = "Execution timed out"
+	}
+	return &errorexception.LimitExceededError{
+		Message: message,
+		Limit:   "context",
+		Err:     err,
+	}
+}
+
+// Enter counts a function call. It panics with a LimitExceededError when
+// calls nest deeper than the limit; every Enter must be paired with Leave.
+func (limits *Limits) Enter() {
+	limits.depth++
+	if limits.maxCallDepth > 0 && limits.depth > limits.maxCallDepth {
+		panic(&errorexception.LimitExceededError{
+			Message: fmt.Sprintf("Maximum call depth of %d exceeded", limits.maxCallDepth),
+			Limit:   "call depth",
+		})
+	}
+}
+
+// Leave ends a call counted by Enter.
+func (limits *Limits) Leave() {
+	limits.depth--
+}
+
+// Alloc accounts for a string, array or object the program created, see
+// object.SizeOf, and panics with a LimitExceededError once the program has
+// allocated more than its quota. Alloc may be called on nil Limits and does
+// nothing without a quota.
+func (limits *Limits) Alloc(val interface{}) {
+	if limits == nil || limits.maxMemoryBytes <= 0 {
+		return
+	}
+	limits.AllocBytes(object.SizeOf(val))
+}
+
+// AllocDeep is Alloc for a value whose nested values are new as well, such
+// as the result of a builtin.
+func (limits *Limits) AllocDeep(val interface{}) {
+	if limits == nil || limits.maxMemoryBytes <= 0 {
+		return
+	}
+	limits.AllocBytes(object.DeepSizeOf(val))
+}
+
+// AllocBytes accounts for size bytes allocated by the program.
+func (limits *Limits) AllocBytes(size int64) {
+	if limits == nil || limits.maxMemoryBytes <= 0 {
+		return
+	}
+	limits.memory = object.AddSize(limits.memory, size)
+	if limits.memory > limits.maxMemoryBytes {
+		panic(&errorexception.LimitExceededError{
+			Message: fmt.Sprintf("Memory limit of %d bytes exceeded", limits.maxMemoryBytes),
+			Limit:   "memory",
+		})
+	}
+}
+
+// Memory returns the number of bytes allocated so far. It is only counted
+// when there is a memory quota.
+func (limits *Limits) Memory() int64 {
+	return limits.memory
+}
+
+// Steps returns the number of steps evaluated so far.
+func (limits *Limits) Steps() int {
+	return limits.steps
+}
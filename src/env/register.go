@@ -0,0 +1,500 @@
+package env
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+var (
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	objectType = reflect.TypeOf((*object.Object)(nil))
+	arrayType  = reflect.TypeOf((*object.Array)(nil))
+)
+
+// RegisterFunc makes the Go function fn callable from scripts as name, see
+// NewFunc.
+func (env *Env) RegisterFunc(name string, fn any) error {
+	builtin, err := NewFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	if env.Builtin == nil {
+		env.Builtin = make(map[string]BuiltinFunc)
+	}
+	env.Builtin[name] = builtin
+	return nil
+}
+
+// NewFunc wraps the Go function fn as a builtin named name, so hosts need not
+// check the arguments themselves:
+//
+//	builtin, err := env.NewFunc("discount", func(price float64, tier string) (float64, error) { ... })
+//
+// Calls with the wrong number of arguments or arguments that do not convert
+// to the parameter types fail with a RuntimeError naming the function and
+// the argument. Parameters may be bool, string, any integer or float type,
+// *object.Object, *object.Array, structs and pointers to structs (see
+// Bind), slices and string-keyed maps of those, and any, which receives the
+// argument as plain Go values (see object.ToGo). A variadic last parameter
+// takes the remaining arguments. fn may return nothing, a value, an error,
+// or a value and an error; a non-nil error fails the call.
+func NewFunc(name string, fn any) (BuiltinFunc, error) {
+	fnVal := reflect.ValueOf(fn)
+	if !fnVal.IsValid() || fnVal.Kind() != reflect.Func || fnVal.IsNil() {
+		return nil, fmt.Errorf("%s: expected a function, got %T", name, fn)
+	}
+	fnType := fnVal.Type()
+	for i := 0; i < fnType.NumIn(); i++ {
+		param := fnType.In(i)
+		if fnType.IsVariadic() && i == fnType.NumIn()-1 {
+			param = param.Elem()
+		}
+		if !supportedType(param) {
+			return nil, fmt.Errorf("%s: unsupported parameter type %s", name, fnType.In(i))
+		}
+	}
+	returnsValue, returnsError := false, false
+	switch fnType.NumOut() {
+	case 0:
+	case 1:
+		returnsError = fnType.Out(0) == errorType
+		returnsValue = !returnsError
+	case 2:
+		if fnType.Out(1) != errorType {
+			return nil, fmt.Errorf("%s: the second result must be an error", name)
+		}
+		returnsValue, returnsError = true, true
+	default:
+		return nil, fmt.Errorf("%s: expected at most 2 results, got %d", name, fnType.NumOut())
+	}
+	if returnsValue && !supportedType(fnType.Out(0)) {
+		return nil, fmt.Errorf("%s: unsupported result type %s", name, fnType.Out(0))
+	}
+
+	return func(args []any) (any, error) {
+		in, err := convertArgs(name, fnType, args)
+		if err != nil {
+			return nil, err
+		}
+		out := fnVal.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return nil, callError(name, err)
+			}
+		}
+		if !returnsValue {
+			return object.NULL, nil
+		}
+		return toScript(out[0], false), nil
+	}, nil
+}
+
+func convertArgs(name string, fnType reflect.Type, args []any) ([]reflect.Value, error) {
+	count := fnType.NumIn()
+	if fnType.IsVariadic() {
+		if len(args) < count-1 {
+			return nil, &errorexception.RuntimeError{
+				Message: fmt.Sprintf("%s() expects at least %s, got %d", name, plural(count-1, "argument"), len(args)),
+			}
+		}
+	} else if len(args) != count {
+		return nil, &errorexception.RuntimeError{
+			Message: fmt.Sprintf("%s() expects %s, got %d", name, plural(count, "argument"), len(args)),
+		}
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if fnType.IsVariadic() && i >= count-1 {
+			param = fnType.In(count - 1).Elem()
+		} else {
+			param = fnType.In(i)
+		}
+		val, err := fromScript(arg, param, fmt.Sprintf("argument %d", i+1))
+		if err != nil {
+			return nil, &errorexception.RuntimeError{
+				Message: fmt.Sprintf("%s() %s", name, err.Error()),
+			}
+		}
+		in[i] = val
+	}
+	return in, nil
+}
+
+// callError reports an error returned by the host function. Errors of the
+// language are passed on as they are.
+func callError(name string, err error) error {
+	if _, ok := err.(interface{ GetMessage() string }); ok {
+		return err
+	}
+	return &errorexception.RuntimeError{
+		Message: fmt.Sprintf("%s() failed: %s", name, err.Error()),
+	}
+}
+
+func supportedType(t reflect.Type) bool {
+	if t == objectType || t == arrayType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Interface,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return t.Kind() != reflect.Interface || t.NumMethod() == 0
+	case reflect.Struct:
+		return true
+	case reflect.Pointer:
+		return t.Elem().Kind() == reflect.Struct
+	case reflect.Slice:
+		return supportedType(t.Elem())
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && supportedType(t.Elem())
+	default:
+		return false
+	}
+}
+
+// fromScript converts a script value to the Go type t. path names the value
+// in errors.
+func fromScript(val any, t reflect.Type, path string) (reflect.Value, error) {
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("%s must be %s, got %s", path, goTypeName(t), scriptTypeName(val))
+	}
+	outOfRange := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("%s must be %s, got %v", path, goTypeName(t), val)
+	}
+	if _, isNull := val.(*object.Null); isNull || val == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+		return mismatch()
+	}
+	if t == objectType {
+		if _, ok := val.(*object.Object); !ok {
+			return mismatch()
+		}
+		return reflect.ValueOf(val), nil
+	}
+	if t == arrayType {
+		if _, ok := val.(*object.Array); !ok {
+			return mismatch()
+		}
+		return reflect.ValueOf(val), nil
+	}
+
+	result := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		if host, ok := val.(*HostObject); ok {
+			result.Set(reflect.ValueOf(host.Value()))
+		} else {
+			result.Set(reflect.ValueOf(object.ToGo(val)))
+		}
+	case reflect.Struct:
+		switch v := val.(type) {
+		case *HostObject:
+			if v.value.Type() != t {
+				return mismatch()
+			}
+			result.Set(v.value)
+		case *object.Object:
+			fields := fieldsOf(t)
+			for _, key := range v.Keys() {
+				index, ok := fields.index[key]
+				if !ok {
+					return reflect.Value{}, fmt.Errorf("%s has no field %q in %s", path, key, t.Name())
+				}
+				prop, _ := v.GetProperty(key)
+				field, err := result.FieldByIndexErr(index)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("%s.%s: %s", path, key, err.Error())
+				}
+				elem, err := fromScript(prop, field.Type(), path+"."+key)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				field.Set(elem)
+			}
+		default:
+			return mismatch()
+		}
+	case reflect.Pointer:
+		host, ok := val.(*HostObject)
+		if !ok || host.value.Type() != t.Elem() {
+			if obj, ok := val.(*object.Object); ok {
+				elem, err := fromScript(obj, t.Elem(), path)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				result = reflect.New(t.Elem())
+				result.Elem().Set(elem)
+				return result, nil
+			}
+			return mismatch()
+		}
+		if host.readOnly {
+			return reflect.Value{}, fmt.Errorf("%s is read-only and cannot be passed as %s", path, t)
+		}
+		result = host.value.Addr()
+	case reflect.Bool:
+		b, ok := val.(bool)
+		if !ok {
+			return mismatch()
+		}
+		result.SetBool(b)
+	case reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return mismatch()
+		}
+		result.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// integers are converted exactly, not through float64
+		n, ok := toInt64(val)
+		if !ok {
+			f, ok := toFloat(val)
+			if !ok {
+				return mismatch()
+			}
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return outOfRange()
+			}
+			n = int64(f)
+		}
+		if result.OverflowInt(n) {
+			return outOfRange()
+		}
+		result.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := toInt64(val)
+		u := uint64(n)
+		if !ok {
+			f, ok := toFloat(val)
+			if !ok {
+				return mismatch()
+			}
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return outOfRange()
+			}
+			u = uint64(f)
+		} else if n < 0 {
+			return outOfRange()
+		}
+		if result.OverflowUint(u) {
+			return outOfRange()
+		}
+		result.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, ok := toFloat(val)
+		if !ok {
+			return mismatch()
+		}
+		if result.OverflowFloat(n) {
+			return outOfRange()
+		}
+		result.SetFloat(n)
+	case reflect.Slice:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return mismatch()
+		}
+		result = reflect.MakeSlice(t, arr.Len(), arr.Len())
+		for i, item := range arr.Items {
+			elem, err := fromScript(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			result.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		obj, ok := val.(*object.Object)
+		if !ok {
+			return mismatch()
+		}
+		result = reflect.MakeMapWithSize(t, obj.Len())
+		for _, key := range obj.Keys() {
+			prop, _ := obj.GetProperty(key)
+			elem, err := fromScript(prop, t.Elem(), fmt.Sprintf("%s.%s", path, key))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+	default:
+		return mismatch()
+	}
+	return result, nil
+}
+
+// toScript converts a Go value to a script value: integers become int, or
+// float64 for unsigned values beyond the range of int, floats float64,
+// slices arrays, string-keyed maps objects with sorted keys and structs
+// HostObjects, read-only if readOnly is set. nil becomes null and values of
+// the language are returned unchanged.
+func toScript(val reflect.Value, readOnly bool) any {
+	if !val.IsValid() {
+		return object.NULL
+	}
+	switch val.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Func:
+		if val.CanInterface() && isRuntimeValue(val.Interface()) {
+			if val.Kind() != reflect.Struct && val.IsNil() {
+				return object.NULL
+			}
+			return val.Interface()
+		}
+	}
+	switch val.Kind() {
+	case reflect.Interface:
+		if val.IsNil() {
+			return object.NULL
+		}
+		return toScript(val.Elem(), readOnly)
+	case reflect.Pointer:
+		if val.IsNil() {
+			return object.NULL
+		}
+		if val.Elem().Kind() == reflect.Struct {
+			return &HostObject{value: val.Elem(), readOnly: readOnly}
+		}
+		return toScript(val.Elem(), readOnly)
+	case reflect.Struct:
+		return newHostObject(val, readOnly)
+	case reflect.Bool:
+		return val.Bool()
+	case reflect.String:
+		return val.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val.Uint() > math.MaxInt {
+			// too large for int: the nearest float rather than a wrapped int
+			return float64(val.Uint())
+		}
+		return int(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.IsNil() {
+			return object.NULL
+		}
+		items := make([]any, val.Len())
+		for i := range items {
+			items[i] = toScript(val.Index(i), readOnly)
+		}
+		return object.NewArray(items)
+	case reflect.Map:
+		if val.IsNil() || val.Type().Key().Kind() != reflect.String {
+			return object.FromGo(val.Interface())
+		}
+		keys := make([]string, 0, val.Len())
+		for _, key := range val.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		obj := object.NewObject()
+		for _, key := range keys {
+			obj.SetProperty(key, toScript(val.MapIndex(reflect.ValueOf(key).Convert(val.Type().Key())), readOnly))
+		}
+		return obj
+	default:
+		return object.FromGo(val.Interface())
+	}
+}
+
+// toInt64 returns an integer script value as an int64.
+func toInt64(val any) (int64, bool) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(val any) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// scriptTypeName names the type of a script value in error messages.
+func scriptTypeName(val any) string {
+	switch v := val.(type) {
+	case nil, *object.Null:
+		return "null"
+	case int, int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case *object.Array:
+		return "array"
+	case *object.Object:
+		return "object"
+	case *HostObject:
+		return v.typeName()
+	case expression.FuncDef, *Closure, BuiltinFunc, Callable:
+		return "function"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
+
+// goTypeName describes the script values a Go type accepts.
+func goTypeName(t reflect.Type) string {
+	if t == objectType {
+		return "an object"
+	}
+	if t == arrayType {
+		return "an array"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a bool"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "an array"
+	case reflect.Map:
+		return "an object"
+	case reflect.Struct:
+		return "a " + t.Name()
+	case reflect.Pointer:
+		return goTypeName(t.Elem())
+	default:
+		return "a " + t.String()
+	}
+}
+
+func plural(count int, word string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, word)
+	}
+	return fmt.Sprintf("%d %ss", count, word)
+}
@@ -0,0 +1,150 @@
+package env_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/object"
+)
+
+func TestNewFuncCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fn   any
+		args []any
+		want any
+		err  string // part of the error message, "" for success
+	}{
+		// integers
+		{"int", func(n int) int { return n + 1 }, []any{41}, 42, ""},
+		{"int from a whole float", func(n int) int { return n }, []any{3.0}, 3, ""},
+		{"int from a fraction", func(n int) int { return n }, []any{3.5}, nil, "argument 1 must be an integer, got 3.5"},
+		{"int8 overflow", func(n int8) int8 { return n }, []any{128}, nil, "argument 1 must be an integer, got 128"},
+		{"int8 underflow", func(n int8) int8 { return n }, []any{-129}, nil, "got -129"},
+		{"int8 bounds", func(lo, hi int8) []int8 { return []int8{lo, hi} }, []any{-128, 127}, "[-128 127]", ""},
+		{"int64 exact", func(n int64) int64 { return n }, []any{int64(math.MaxInt64)}, math.MaxInt64, ""},
+		{"int64 exact from int", func(n int64) int64 { return n - 1 }, []any{math.MinInt64 + 1}, math.MinInt64, ""},
+		{"int64 from a float too large", func(n int64) int64 { return n }, []any{9.3e18}, nil, "must be an integer, got 9.3e+18"},
+		{"int64 from a huge float", func(n int64) int64 { return n }, []any{1e300}, nil, "must be an integer"},
+		{"uint from a negative", func(n uint) uint { return n }, []any{-1}, nil, "must be a non-negative integer, got -1"},
+		{"uint8 overflow", func(n uint8) uint8 { return n }, []any{256}, nil, "got 256"},
+		{"uint64 max result", func() uint64 { return math.MaxUint64 }, nil, float64(math.MaxUint64), ""},
+		{"uint64 small result", func() uint64 { return 7 }, nil, 7, ""},
+		{"int from a string", func(n int) int { return n }, []any{"1"}, nil, "argument 1 must be an integer, got string"},
+
+		// floats
+		{"float64", func(f float64) float64 { return f * 2 }, []any{1.25}, 2.5, ""},
+		{"float64 from int", func(f float64) float64 { return f }, []any{2}, 2.0, ""},
+		{"float32 overflow", func(f float32) float32 { return f }, []any{1e300}, nil, "argument 1 must be a number, got 1e+300"},
+		{"float32 in range", func(f float32) float64 { return float64(f) }, []any{0.5}, 0.5, ""},
+		{"float32 result", func() float32 { return 1.5 }, nil, 1.5, ""},
+
+		// variadic parameters
+		{"variadic none", func(sep string, parts ...string) string { return strings.Join(parts, sep) }, []any{"-"}, "", ""},
+		{"variadic many", func(sep string, parts ...string) string { return strings.Join(parts, sep) }, []any{"-", "a", "b", "c"}, "a-b-c", ""},
+		{"variadic ints", func(ns ...int) int { return len(ns) }, []any{1, 2, 3, 4}, 4, ""},
+		{"variadic bad element", func(sep string, parts ...string) string { return "" }, []any{"-", "a", 2}, nil, "argument 3 must be a string, got number"},
+		{"variadic missing fixed", func(sep string, parts ...string) string { return "" }, nil, nil, "expects at least 1 argument, got 0"},
+
+		// argument counts
+		{"too few", func(a, b int) int { return a + b }, []any{1}, nil, "f() expects 2 arguments, got 1"},
+		{"too many", func(a int) int { return a }, []any{1, 2}, nil, "f() expects 1 argument, got 2"},
+		{"none expected", func() int { return 1 }, []any{1}, nil, "f() expects 0 arguments, got 1"},
+
+		// results and errors
+		{"no result", func(int) {}, []any{1}, object.NULL, ""},
+		{"error only nil", func() error { return nil }, nil, object.NULL, ""},
+		{"error only", func() error { return errors.New("boom") }, nil, nil, "f() failed: boom"},
+		{"value and error", func(n int) (int, error) { return n * 2, nil }, []any{4}, 8, ""},
+		{"value and failing error", func(n int) (int, error) { return 0, fmt.Errorf("bad %d", n) }, []any{4}, nil, "f() failed: bad 4"},
+		{"nil slice result", func() []int { return nil }, nil, object.NULL, ""},
+		{"null to pointer", func(o *object.Object) bool { return o == nil }, []any{object.NULL}, true, ""},
+		{"null to int", func(n int) int { return n }, []any{object.NULL}, nil, "argument 1 must be an integer, got null"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fn, err := env.NewFunc("f", tc.fn)
+			if err != nil {
+				t.Fatalf("NewFunc: %v", err)
+			}
+			got, err := fn(tc.args)
+			if tc.err != "" {
+				var runtimeErr *errorexception.RuntimeError
+				if !errors.As(err, &runtimeErr) || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("err = %v, want a RuntimeError containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if arr, ok := got.(*object.Array); ok {
+				got = fmt.Sprint(arr.Items)
+			}
+			if got != tc.want {
+				t.Errorf("result = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewFuncErrorsPassedOn(t *testing.T) {
+	policyErr := &errorexception.PolicyError{Message: "denied"}
+	fn, err := env.NewFunc("f", func() error { return policyErr })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn(nil); err != policyErr {
+		t.Errorf("err = %v, want the PolicyError unchanged", err)
+	}
+}
+
+func TestNewFuncRejectsSignatures(t *testing.T) {
+	var nilFunc func()
+	for _, tc := range []struct {
+		name string
+		fn   any
+		err  string
+	}{
+		{"nil", nil, "expected a function, got <nil>"},
+		{"nil function", nilFunc, "expected a function, got func()"},
+		{"not a function", 42, "expected a function, got int"},
+		{"channel parameter", func(chan int) {}, "unsupported parameter type chan int"},
+		{"int-keyed map", func(map[int]string) {}, "unsupported parameter type map[int]string"},
+		{"variadic channel", func(...chan int) {}, "unsupported parameter type []chan int"},
+		{"second result not error", func() (int, int) { return 0, 0 }, "the second result must be an error"},
+		{"three results", func() (int, int, error) { return 0, 0, nil }, "expected at most 2 results, got 3"},
+		{"unsupported result", func() chan int { return nil }, "unsupported result type chan int"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := env.NewFunc("f", tc.fn); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("err = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	host := &env.Env{}
+	if err := host.RegisterFunc("add", func(a, b int) int { return a + b }); err != nil {
+		t.Fatal(err)
+	}
+	add, ok := host.GetBuiltin("add")
+	if !ok {
+		t.Fatal("add is not registered")
+	}
+	if got, err := add([]any{2, 3}); err != nil || got != 5 {
+		t.Errorf("add(2, 3) = %v, %v", got, err)
+	}
+
+	if err := host.RegisterFunc("bad", 1); err == nil {
+		t.Error("registering a non-function succeeded")
+	}
+	if _, ok := host.GetBuiltin("bad"); ok {
+		t.Error("a rejected function was registered")
+	}
+}
@@ -0,0 +1,426 @@
+package env
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int             `json:"version"`
+	Scopes  []snapshotScope `json:"scopes"` // the snapshotted env first
+}
+
+type snapshotScope struct {
+	Parent  int             `json:"parent"` // index in Scopes, -1 for the parent given to Restore
+	Vars    []snapshotEntry `json:"vars,omitempty"`
+	Funcs   []snapshotEntry `json:"funcs,omitempty"`
+	Module  string          `json:"module,omitempty"`
+	Exports []string        `json:"exports,omitempty"`
+}
+
+type snapshotEntry struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// Snapshot serialises env to JSON so that Restore can recreate it later,
+// e.g. to resume a workflow in another process. It saves the variables and
+// functions of env and its parents up to stop, which is not included, or up
+// to the first frozen scope, together with the module scopes functions
+// imported from modules refer to. Builtins, limits and module loaders
+// belong to the host and are not saved.
+//
+// Values keep their types, arrays and objects referenced from several
+// places stay shared, and functions are saved as their syntax tree. A
+// closure over a scope that is not saved is restored over the parent given
+// to Restore, like the outermost saved scope. Go
+// structs are saved as objects with their JSON fields. A variable holding a
+// builtin or another host value fails the snapshot.
+func (env *Env) Snapshot(stop *Env) ([]byte, error) {
+	writer := &snapshotWriter{
+		stop:  stop,
+		index: make(map[*Env]int),
+		ids:   make(map[interface{}]int),
+	}
+	writer.scope(env)
+	// scopes are encoded in order so that Restore meets every shared value
+	// before its references
+	for i := 0; i < len(writer.envs); i++ {
+		if err := writer.encodeScope(i); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(snapshot{Version: snapshotVersion, Scopes: writer.scopes})
+}
+
+type snapshotWriter struct {
+	stop   *Env
+	envs   []*Env
+	scopes []snapshotScope
+	index  map[*Env]int
+	ids    map[interface{}]int // the *object.Array and *object.Object values encoded
+}
+
+// scope returns the index of env in the snapshot, adding it if needed, or -1
+// for scopes that are not saved.
+func (writer *snapshotWriter) scope(env *Env) int {
+	if env == nil || env == writer.stop || env.frozen {
+		return -1
+	}
+	if i, ok := writer.index[env]; ok {
+		return i
+	}
+	i := len(writer.envs)
+	writer.index[env] = i
+	writer.envs = append(writer.envs, env)
+	writer.scopes = append(writer.scopes, snapshotScope{})
+	writer.scopes[i].Parent = writer.scope(env.Parent)
+	return i
+}
+
+func (writer *snapshotWriter) encodeScope(i int) error {
+	env := writer.envs[i]
+	vars := make(map[string]interface{}, len(env.Vars))
+	for name, val := range env.Vars {
+		vars[name] = val
+	}
+	if env.Scope != nil {
+		// the locals of a function frame are restored as plain variables
+		for name, slot := range env.Scope.Slots {
+			if env.Slots[slot] != Unset {
+				vars[name] = env.Slots[slot]
+			}
+		}
+	}
+
+	scope := snapshotScope{Parent: writer.scopes[i].Parent, Module: env.ModuleName, Exports: env.Exports}
+	for _, name := range sortedKeys(vars) {
+		encoded, err := writer.value(vars[name])
+		if err != nil {
+			return fmt.Errorf("cannot snapshot variable %s: %w", name, err)
+		}
+		scope.Vars = append(scope.Vars, snapshotEntry{Name: name, Value: encoded})
+	}
+	funcs := make(map[string]interface{}, len(env.Funcs))
+	for name, fn := range env.Funcs {
+		funcs[name] = fn
+	}
+	for _, name := range sortedKeys(funcs) {
+		encoded, err := expression.MarshalExpr(funcs[name])
+		if err != nil {
+			return fmt.Errorf("cannot snapshot function %s: %w", name, err)
+		}
+		scope.Funcs = append(scope.Funcs, snapshotEntry{Name: name, Value: json.RawMessage(encoded)})
+	}
+	writer.scopes[i] = scope
+	return nil
+}
+
+// value encodes a runtime value. Numbers without a tag are float64.
+func (writer *snapshotWriter) value(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case nil, *object.Null:
+		return nil, nil
+	case bool, string:
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]interface{}{"float": fmt.Sprint(v)}, nil
+		}
+		return v, nil
+	case int:
+		return map[string]interface{}{"int": v}, nil
+	case int64:
+		return map[string]interface{}{"int64": v}, nil
+	case *object.Array:
+		if id, ok := writer.ids[v]; ok {
+			return map[string]interface{}{"ref": id}, nil
+		}
+		id := len(writer.ids) + 1
+		writer.ids[v] = id
+		items := make([]interface{}, v.Len())
+		for i, item := range v.Items {
+			encoded, err := writer.value(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = encoded
+		}
+		return map[string]interface{}{"id": id, "array": items}, nil
+	case *object.Object:
+		if id, ok := writer.ids[v]; ok {
+			return map[string]interface{}{"ref": id}, nil
+		}
+		id := len(writer.ids) + 1
+		writer.ids[v] = id
+		pairs := make([]interface{}, 0, v.Len())
+		for _, key := range v.Keys() {
+			prop, _ := v.GetProperty(key)
+			encoded, err := writer.value(prop)
+			if err != nil {
+				return nil, fmt.Errorf("%w (at key %q)", err, key)
+			}
+			pairs = append(pairs, []interface{}{key, encoded})
+		}
+		return map[string]interface{}{"id": id, "object": pairs}, nil
+	case *HostObject:
+		data, err := v.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		plain, err := object.ParseJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		return writer.value(plain)
+	case expression.FuncDef:
+		return writer.function("func", v, nil)
+	case *Closure:
+		// scope is -1, like a scope's parent, for the parent given to Restore
+		return writer.function("closure", v.Func, map[string]interface{}{"scope": writer.scope(v.Env)})
+	case Callable:
+		return writer.function("func", v.Definition(), nil)
+	case BuiltinFunc:
+		return nil, errors.New("builtin functions cannot be saved")
+	default:
+		return nil, fmt.Errorf("values of type %T cannot be saved", val)
+	}
+}
+
+func (writer *snapshotWriter) function(tag string, fn expression.FuncDef, fields map[string]interface{}) (interface{}, error) {
+	encoded, err := expression.MarshalExpr(fn)
+	if err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields[tag] = json.RawMessage(encoded)
+	return fields, nil
+}
+
+// Restore recreates the scopes saved by Snapshot and returns the one that
+// was snapshotted. The outermost saved scope gets parent as its parent,
+// which provides the builtins and whatever else was not saved.
+func Restore(data []byte, parent *Env) (*Env, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var saved struct {
+		Version int `json:"version"`
+		Scopes  []struct {
+			Parent  int      `json:"parent"`
+			Vars    []rawKV  `json:"vars"`
+			Funcs   []rawKV  `json:"funcs"`
+			Module  string   `json:"module"`
+			Exports []string `json:"exports"`
+		} `json:"scopes"`
+	}
+	if err := decoder.Decode(&saved); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if saved.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", saved.Version)
+	}
+	if len(saved.Scopes) == 0 {
+		return nil, errors.New("invalid snapshot: no scopes")
+	}
+
+	reader := &snapshotReader{
+		parent: parent,
+		envs:   make([]*Env, len(saved.Scopes)),
+		ids:    make(map[int]interface{}),
+	}
+	for i := range saved.Scopes {
+		reader.envs[i] = &Env{
+			Vars:       make(map[string]interface{}),
+			Funcs:      make(map[string]expression.FuncDef),
+			ModuleName: saved.Scopes[i].Module,
+			Exports:    saved.Scopes[i].Exports,
+		}
+	}
+	for i, scope := range saved.Scopes {
+		env := reader.envs[i]
+		if parent != nil {
+			env.Limits = parent.Limits
+		}
+		if scope.Parent < 0 {
+			env.Parent = parent
+		} else if scope.Parent < len(reader.envs) {
+			env.Parent = reader.envs[scope.Parent]
+		} else {
+			return nil, fmt.Errorf("invalid snapshot: scope %d has no parent %d", i, scope.Parent)
+		}
+		for _, entry := range scope.Vars {
+			val, err := reader.value(entry.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot restore variable %s: %w", entry.Name, err)
+			}
+			env.Vars[entry.Name] = val
+		}
+		for _, entry := range scope.Funcs {
+			fn, err := decodeFunc(entry.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot restore function %s: %w", entry.Name, err)
+			}
+			env.Funcs[entry.Name] = fn
+		}
+	}
+	return reader.envs[0], nil
+}
+
+type rawKV struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+type snapshotReader struct {
+	parent *Env
+	envs   []*Env
+	ids    map[int]interface{}
+}
+
+func (reader *snapshotReader) value(data json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return reader.decode(decoded)
+}
+
+func (reader *snapshotReader) decode(data interface{}) (interface{}, error) {
+	switch v := data.(type) {
+	case nil:
+		return object.NULL, nil
+	case bool, string:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case map[string]interface{}:
+		return reader.decodeTagged(v)
+	default:
+		return nil, fmt.Errorf("invalid value %v", data)
+	}
+}
+
+func (reader *snapshotReader) decodeTagged(fields map[string]interface{}) (interface{}, error) {
+	if n, ok := fields["int"].(json.Number); ok {
+		i, err := strconv.ParseInt(string(n), 10, 64)
+		return int(i), err
+	}
+	if n, ok := fields["int64"].(json.Number); ok {
+		return strconv.ParseInt(string(n), 10, 64)
+	}
+	if s, ok := fields["float"].(string); ok {
+		var f float64
+		_, err := fmt.Sscan(s, &f)
+		return f, err
+	}
+	if n, ok := fields["ref"].(json.Number); ok {
+		id, _ := n.Int64()
+		val, ok := reader.ids[int(id)]
+		if !ok {
+			return nil, fmt.Errorf("reference to unknown value %d", id)
+		}
+		return val, nil
+	}
+	id := 0
+	if n, ok := fields["id"].(json.Number); ok {
+		i, _ := n.Int64()
+		id = int(i)
+	}
+	if items, ok := fields["array"].([]interface{}); ok {
+		arr := object.NewArray(make([]interface{}, len(items)))
+		reader.ids[id] = arr
+		for i, item := range items {
+			val, err := reader.decode(item)
+			if err != nil {
+				return nil, err
+			}
+			arr.Items[i] = val
+		}
+		return arr, nil
+	}
+	if pairs, ok := fields["object"].([]interface{}); ok {
+		obj := object.NewObject()
+		reader.ids[id] = obj
+		for _, pair := range pairs {
+			kv, ok := pair.([]interface{})
+			if !ok || len(kv) != 2 {
+				return nil, fmt.Errorf("invalid object property %v", pair)
+			}
+			key, ok := kv[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid object key %v", kv[0])
+			}
+			val, err := reader.decode(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			obj.SetProperty(key, val)
+		}
+		return obj, nil
+	}
+	if fn, ok := fields["func"]; ok {
+		return decodeFuncValue(fn)
+	}
+	if fn, ok := fields["closure"]; ok {
+		def, err := decodeFuncValue(fn)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := fields["scope"].(json.Number)
+		scope, err := n.Int64()
+		if err != nil || scope < -1 || int(scope) >= len(reader.envs) {
+			return nil, fmt.Errorf("closure over unknown scope %v", fields["scope"])
+		}
+		if scope == -1 {
+			return &Closure{Func: def, Env: reader.parent}, nil
+		}
+		return &Closure{Func: def, Env: reader.envs[scope]}, nil
+	}
+	return nil, fmt.Errorf("invalid value %v", fields)
+}
+
+// decodeFuncValue decodes a function nested in a decoded value, re-encoding
+// it for expression.UnmarshalExpr.
+func decodeFuncValue(data interface{}) (expression.FuncDef, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return expression.FuncDef{}, err
+	}
+	return decodeFunc(encoded)
+}
+
+func decodeFunc(data []byte) (expression.FuncDef, error) {
+	expr, err := expression.UnmarshalExpr(data)
+	if err != nil {
+		return expression.FuncDef{}, err
+	}
+	fn, ok := expr.(expression.FuncDef)
+	if !ok {
+		return expression.FuncDef{}, fmt.Errorf("expected a function, got %T", expr)
+	}
+	return fn, nil
+}
+
+func sortedKeys(entries map[string]interface{}) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+package env_test
+
+import (
+	"strings"
+	"testing"
+
+	lang "theparadance.com/quan-lang/quan-lang"
+	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
+	debuglevel "theparadance.com/quan-lang/src/debug/debug-level"
+	"theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/object"
+	systemconsole "theparadance.com/quan-lang/src/system-console"
+)
+
+const snapshotSetup = `
+fn double(x) { return x * 2; }
+fn addRate(x) { return x + rate; }
+fn addK(x) { return x + k; }
+rate = 5;
+triple = x => x * 3;
+shared = { n: 1, tags: ["a"] };
+pair = [shared, shared];
+holder = { item: shared };
+cyc = { name: "c" };
+cyc.self = cyc;
+list = [1, 2];
+list.push(list);
+count = 7;
+ratio = 0.5;
+label = "ready";
+flag = true;
+none = null;
+`
+
+// snapshotCheck uses every saved value and changes the shared ones, so
+// that restored values must behave like the originals, not just print
+// like them.
+const snapshotCheck = `
+print(double(4), addRate(1), triple(3), near(2), far(2), side(2));
+shared.n = shared.n + 1;
+print(pair[1].n, holder.item.n, pair[0].tags.length);
+print(cyc.self.self.name, list[2][2][0], list.length);
+print(big, type(big), count, ratio, label, flag, none);
+print(toJson(holder));
+`
+
+// runScript runs source in a child of state and returns the scope it ran in.
+func runScript(t *testing.T, source string, state *env.Env, console *systemconsole.VirtualSystemConsole) *env.Env {
+	t.Helper()
+	option := lang.NewExecuationOption(console, lang.RELEASE_MODE, &[]debuglevel.DebugLevel{})
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("%v\n%s", r, console.String())
+		}
+	}()
+	result, _ := lang.Execuate(source, state, option)
+	return result.Env
+}
+
+func newHost(console systemconsole.SystemConsole, k int) *env.Env {
+	return &env.Env{
+		Builtin: builtinfunc.BuildInFuncs(console),
+		Vars:    map[string]interface{}{"k": k},
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	console := systemconsole.NewVirtualSystemConsole()
+	host := newHost(console, 100)
+	saved := runScript(t, snapshotSetup, &env.Env{Parent: host}, console)
+	// int64 values come from the host; scripts make ints and floats
+	saved.SetVar("big", int64(1)<<62+1)
+	addRate, _ := saved.GetFunc("addRate")
+	addK, _ := saved.GetFunc("addK")
+	// closures over a saved scope, over the host, which is not saved and is
+	// replaced by the scope given to Restore, and over a scope of their own
+	// below the host, which is saved with them
+	saved.SetVar("near", &env.Closure{Func: addRate, Env: saved})
+	saved.SetVar("far", &env.Closure{Func: addK, Env: host})
+	saved.SetVar("side", &env.Closure{Func: addRate, Env: &env.Env{
+		Vars:   map[string]interface{}{"rate": 1000},
+		Parent: host,
+	}})
+
+	data, err := saved.Snapshot(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// snapshots are deterministic
+	if again, _ := saved.Snapshot(host); string(again) != string(data) {
+		t.Errorf("snapshot changed between calls\n%s\n%s", data, again)
+	}
+
+	restoredConsole := systemconsole.NewVirtualSystemConsole()
+	restoredHost := newHost(restoredConsole, 100)
+	restored, err := env.Restore(data, restoredHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("values", func(t *testing.T) {
+		big, _ := restored.GetVar("big")
+		if big != int64(1)<<62+1 {
+			t.Errorf("big = %T %v, want int64 %d", big, big, int64(1)<<62+1)
+		}
+		count, _ := restored.GetVar("count")
+		if count != 7.0 {
+			t.Errorf("count = %#v, want 7.0", count)
+		}
+		pair, _ := restored.GetVar("pair")
+		shared, _ := restored.GetVar("shared")
+		items := pair.(*object.Array).Items
+		if items[0] != shared || items[1] != shared {
+			t.Error("pair does not hold the shared object twice")
+		}
+		cyc, _ := restored.GetVar("cyc")
+		if self, _ := cyc.(*object.Object).GetProperty("self"); self != cyc {
+			t.Error("cyc.self is not cyc")
+		}
+		list, _ := restored.GetVar("list")
+		if list.(*object.Array).Items[2] != list {
+			t.Error("list does not contain itself")
+		}
+		far, _ := restored.GetVar("far")
+		if closure, ok := far.(*env.Closure); !ok || closure.Env != restoredHost {
+			t.Errorf("far = %#v, want a closure over the restore parent", far)
+		}
+	})
+
+	t.Run("behaviour", func(t *testing.T) {
+		runScript(t, snapshotCheck, restored, restoredConsole)
+		runScript(t, snapshotCheck, saved, console)
+		if got, want := restoredConsole.String(), console.String(); got != want {
+			t.Errorf("restored env behaves differently\n--- restored\n%s--- original\n%s", got, want)
+		}
+		for _, want := range []string{"8\n6\n9\n7\n102\n1002\n", "2\n2\n1\n", "c\n1\n3\n", "4611686018427387905\nint\n7\n0.5\n"} {
+			if !strings.Contains(restoredConsole.String(), want) {
+				t.Errorf("output %q does not contain %q", restoredConsole.String(), want)
+			}
+		}
+	})
+}
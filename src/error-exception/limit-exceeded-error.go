@@ -0,0 +1,24 @@
+package errorexception
+
+// LimitExceededError stops a program that ran out of one of the limits set
+// on its execution. Limit is "steps", "call depth", "memory" or "context";
+// for the latter Err is the context's error, so errors.Is(err,
+// context.DeadlineExceeded) reports a timeout.
+type LimitExceededError struct {
+	Message         string `json:"message"`
+	Limit           string `json:"limit"`
+	Err             error  `json:"-"`
+	ConsoleMessages string `json:"console_messages,omitempty"`
+}
+
+func (e *LimitExceededError) Error() string {
+	return e.Message
+}
+
+func (e *LimitExceededError) GetMessage() string {
+	return e.Message
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,14 @@
+package errorexception
+
+type ModuleError struct {
+	Message         string `json:"message"`
+	ConsoleMessages string `json:"console_messages,omitempty"`
+}
+
+func (e *ModuleError) Error() string {
+	return e.Message
+}
+
+func (e *ModuleError) GetMessage() string {
+	return e.Message
+}
@@ -0,0 +1,16 @@
+package errorexception
+
+// PolicyError is returned when a script does something its execution policy
+// forbids, such as calling a disabled builtin or fetching a denied URL.
+type PolicyError struct {
+	Message         string `json:"message"`
+	ConsoleMessages string `json:"console_messages,omitempty"`
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+func (e *PolicyError) GetMessage() string {
+	return e.Message
+}
@@ -0,0 +1,228 @@
+package expression
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// nodeTypes names every node type for MarshalExpr and UnmarshalExpr.
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []Expr{
+		NullExpr{}, NumberExpr{}, StringExpr{}, TemplateStringExpr{}, VarExpr{},
+		AssignExpr{}, BinaryExpr{}, IfExpr{}, MatchExpr{}, WildcardPattern{},
+		RangePattern{}, TypePattern{}, BindingPattern{}, ShapePattern{},
+		TernaryExpr{}, FuncDef{}, LocalExpr{}, FuncCall{}, CallExpr{},
+		ReturnExpr{}, BooleanExpr{}, ObjectExpr{}, MemberExpr{}, ArrayExpr{},
+		SpreadExpr{}, ObjectPattern{}, ArrayPattern{}, IndexExpr{},
+		ImportExpr{}, ExportExpr{},
+	} {
+		t := reflect.TypeOf(node)
+		nodeTypes[t.Name()] = t
+	}
+}
+
+var exprType = reflect.TypeOf((*Expr)(nil)).Elem()
+
+// MarshalExpr encodes an expression, including what the resolver added to
+// it, as JSON that UnmarshalExpr turns back into the same expression. Every
+// node is an object whose "node" field names its type.
+func MarshalExpr(expr Expr) ([]byte, error) {
+	encoded, err := encodeNode(reflect.ValueOf(&expr).Elem())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalExpr decodes an expression encoded by MarshalExpr.
+func UnmarshalExpr(data []byte) (Expr, error) {
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	val, err := decodeNode(decoded, exprType)
+	if err != nil {
+		return nil, err
+	}
+	return val.Interface(), nil
+}
+
+func encodeNode(val reflect.Value) (interface{}, error) {
+	switch val.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if val.IsNil() {
+			return nil, nil
+		}
+		if val.Kind() == reflect.Interface {
+			elem := val.Elem()
+			if _, ok := nodeTypes[elem.Type().Name()]; !ok || elem.Type().PkgPath() != exprType.PkgPath() {
+				return nil, fmt.Errorf("cannot encode expression of type %s", elem.Type())
+			}
+			encoded, err := encodeNode(elem)
+			if err != nil {
+				return nil, err
+			}
+			encoded.(map[string]interface{})["node"] = elem.Type().Name()
+			return encoded, nil
+		}
+		return encodeNode(val.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{}, val.NumField())
+		for i := 0; i < val.NumField(); i++ {
+			encoded, err := encodeNode(val.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			fields[val.Type().Field(i).Name] = encoded
+		}
+		return fields, nil
+	case reflect.Slice:
+		if val.IsNil() {
+			return nil, nil
+		}
+		items := make([]interface{}, val.Len())
+		for i := range items {
+			encoded, err := encodeNode(val.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = encoded
+		}
+		return items, nil
+	case reflect.Map:
+		if val.IsNil() {
+			return nil, nil
+		}
+		entries := make(map[string]interface{}, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			encoded, err := encodeNode(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			entries[iter.Key().String()] = encoded
+		}
+		return entries, nil
+	case reflect.Float32, reflect.Float64:
+		// JSON has no NaN or infinities, which constant folding can produce
+		f := val.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprint(f), nil
+		}
+		return f, nil
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return val.Interface(), nil
+	default:
+		return nil, fmt.Errorf("cannot encode %s in an expression", val.Type())
+	}
+}
+
+func decodeNode(data interface{}, t reflect.Type) (reflect.Value, error) {
+	result := reflect.New(t).Elem()
+	if data == nil {
+		return result, nil
+	}
+	invalid := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("invalid %s in encoded expression: %v", t, data)
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return invalid()
+		}
+		name, _ := fields["node"].(string)
+		nodeType, ok := nodeTypes[name]
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("unknown expression node %q", name)
+		}
+		node, err := decodeNode(fields, nodeType)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		result.Set(node)
+	case reflect.Pointer:
+		elem, err := decodeNode(data, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		result = reflect.New(t.Elem())
+		result.Elem().Set(elem)
+	case reflect.Struct:
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return invalid()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field, err := decodeNode(fields[t.Field(i).Name], t.Field(i).Type)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			result.Field(i).Set(field)
+		}
+	case reflect.Slice:
+		items, ok := data.([]interface{})
+		if !ok {
+			return invalid()
+		}
+		result = reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			elem, err := decodeNode(item, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			result.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		entries, ok := data.(map[string]interface{})
+		if !ok {
+			return invalid()
+		}
+		result = reflect.MakeMapWithSize(t, len(entries))
+		for key, entry := range entries {
+			elem, err := decodeNode(entry, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+	case reflect.Float32, reflect.Float64:
+		switch v := data.(type) {
+		case float64:
+			result.SetFloat(v)
+		case string:
+			var f float64
+			if _, err := fmt.Sscan(v, &f); err != nil {
+				return invalid()
+			}
+			result.SetFloat(f)
+		default:
+			return invalid()
+		}
+	case reflect.Int, reflect.Int64:
+		n, ok := data.(float64)
+		if !ok {
+			return invalid()
+		}
+		result.SetInt(int64(n))
+	case reflect.String:
+		s, ok := data.(string)
+		if !ok {
+			return invalid()
+		}
+		result.SetString(s)
+	case reflect.Bool:
+		b, ok := data.(bool)
+		if !ok {
+			return invalid()
+		}
+		result.SetBool(b)
+	default:
+		return invalid()
+	}
+	return result, nil
+}
@@ -39,6 +39,50 @@ type IfExpr struct {
 	Else      []Expr
 }
 
+// MatchExpr is `match (subject) { pattern if guard => result, ... }`. It
+// evaluates to the result of the first arm whose pattern matches.
+type MatchExpr struct {
+	Subject Expr
+	Arms    []MatchArm
+}
+
+type MatchArm struct {
+	Pattern Expr // see the *Pattern types below; literals match by equality
+	Guard   Expr // optional `if` condition, nil if absent
+	Body    []Expr
+}
+
+// WildcardPattern is `_` and matches anything.
+type WildcardPattern struct{}
+
+// RangePattern is `low..high` and matches numbers in the inclusive range.
+type RangePattern struct {
+	Low  Expr
+	High Expr
+}
+
+// TypePattern is a type name such as `string` and matches values of that type.
+type TypePattern struct {
+	Type string
+}
+
+// BindingPattern is a plain name; it matches anything and binds the value.
+type BindingPattern struct {
+	Name string
+}
+
+// ShapePattern is `{ key: pattern, other }` and matches objects that have
+// every listed key with a matching value. A key without a pattern must be
+// present and not null, and binds its value to a variable of the same name.
+type ShapePattern struct {
+	Properties []ShapeProperty
+}
+
+type ShapeProperty struct {
+	Key     string
+	Pattern Expr
+}
+
 type TernaryExpr struct {
 	Condition  Expr
 	TrueValue  Expr
@@ -49,6 +93,22 @@ type FuncDef struct {
 	Name   string
 	Params []string
 	Body   []Expr
+	Scope  *Scope // set by the resolver, nil for unresolved functions
+}
+
+// Scope lists the local variables of a resolved function. Parameters come
+// first, and every local is stored in the slot with its index.
+type Scope struct {
+	Names []string
+	Slots map[string]int
+}
+
+// LocalExpr is a variable the resolver found in a function's slots. Depth is
+// the number of `match` arm scopes between the reference and the function.
+type LocalExpr struct {
+	Name  string
+	Depth int
+	Slot  int
 }
 
 type FuncCall struct {
@@ -57,8 +117,9 @@ type FuncCall struct {
 }
 
 type CallExpr struct {
-	Callee Expr // Can be VarExpr, FuncExpr, etc.
-	Args   []Expr
+	Callee   Expr // Can be VarExpr, FuncExpr, etc.
+	Args     []Expr
+	Optional bool // f?.() evaluates to null when f is null
 }
 
 type ReturnExpr struct {
@@ -70,19 +131,68 @@ type BooleanExpr struct {
 }
 
 type ObjectExpr struct {
-	Pairs map[string]Expr
+	Pairs []ObjectPair // in source order
+}
+
+type ObjectPair struct {
+	Key   string
+	Value Expr
 }
 
 type MemberExpr struct {
 	Object   Expr   // e.g. VarExpr{Name: "a"}
 	Property string // e.g. "x"
+	Optional bool   // a?.x evaluates to null when a is null
 }
 
 type ArrayExpr struct {
 	Elements []Expr
 }
 
+// SpreadExpr is `...value` inside an array or object literal.
+type SpreadExpr struct {
+	Value Expr
+}
+
+// ObjectPattern is the target of `{ a, b: c, d = 1, ...rest } = value`.
+type ObjectPattern struct {
+	Properties []PatternElement
+	Rest       Expr // receives the remaining properties, nil if absent
+}
+
+// ArrayPattern is the target of `[a, b = 1, ...rest] = value`.
+type ArrayPattern struct {
+	Elements []PatternElement
+	Rest     Expr // receives the remaining elements, nil if absent
+}
+
+type PatternElement struct {
+	Key     string // property name, only used by ObjectPattern
+	Target  Expr   // VarExpr, MemberExpr, IndexExpr or a nested pattern
+	Default Expr   // used when the value is null, nil if absent
+}
+
 type IndexExpr struct {
-	Array Expr
-	Index Expr
+	Array    Expr
+	Index    Expr
+	Optional bool // a?.[i] evaluates to null when a is null
+}
+
+// ImportExpr is `import { a, b as c } from "./lib.qlang"`. With no names the
+// module is only executed.
+type ImportExpr struct {
+	Names []ImportName
+	Path  string
+}
+
+type ImportName struct {
+	Name  string // name exported by the module
+	Alias string // name bound in the importing scope
+}
+
+// ExportExpr is `export fn f() {}`, `export x = 1` or `export { a, b }`.
+// Decl is nil for the list form.
+type ExportExpr struct {
+	Decl  Expr
+	Names []string
 }
@@ -54,6 +54,13 @@ func convert(expr *expression.Expr) *map[string]interface{} {
 			"type": "VarExpr",
 			"name": e.Name,
 		}
+	case expression.LocalExpr:
+		jsondata = map[string]interface{}{
+			"type":  "LocalExpr",
+			"name":  e.Name,
+			"depth": e.Depth,
+			"slot":  e.Slot,
+		}
 	case expression.IfExpr:
 		jsondata = map[string]interface{}{
 			"type":      "IfExpr",
@@ -85,6 +92,15 @@ func convert(expr *expression.Expr) *map[string]interface{} {
 			"args": e.Args,
 		}
 		jsondata["args"] = ExpressionToJson(&e.Args)
+	case expression.CallExpr:
+		jsondata = map[string]interface{}{
+			"type":     "CallExpr",
+			"callee":   e.Callee,
+			"args":     e.Args,
+			"optional": e.Optional,
+		}
+		jsondata["callee"] = convert(&e.Callee)
+		jsondata["args"] = ExpressionToJson(&e.Args)
 	case expression.BooleanExpr:
 		jsondata = map[string]interface{}{
 			"type":  "BooleanExpr",
@@ -95,16 +111,20 @@ func convert(expr *expression.Expr) *map[string]interface{} {
 			"type":  "ObjectExpr",
 			"pairs": e.Pairs,
 		}
-		d := make(map[string]interface{})
-		for key, value := range e.Pairs {
-			d[key] = convert(&value)
+		pairs := make([]map[string]interface{}, len(e.Pairs))
+		for index, pair := range e.Pairs {
+			pairs[index] = map[string]interface{}{
+				"key":   pair.Key,
+				"value": convert(&pair.Value),
+			}
 		}
-		jsondata["pairs"] = d
+		jsondata["pairs"] = pairs
 	case expression.MemberExpr:
 		jsondata = map[string]interface{}{
 			"type":     "MemberExpr",
 			"object":   e.Object,
 			"property": e.Property,
+			"optional": e.Optional,
 		}
 		jsondata["object"] = convert(&e.Object)
 	case expression.TemplateStringExpr:
@@ -121,12 +141,110 @@ func convert(expr *expression.Expr) *map[string]interface{} {
 		jsondata["elements"] = ExpressionToJson(&e.Elements)
 	case expression.IndexExpr:
 		jsondata = map[string]interface{}{
-			"type":  "IndexExpr",
-			"array": e.Array,
-			"index": e.Index,
+			"type":     "IndexExpr",
+			"array":    e.Array,
+			"index":    e.Index,
+			"optional": e.Optional,
 		}
 		jsondata["array"] = convert(&e.Array)
 		jsondata["index"] = convert(&e.Index)
+	case expression.SpreadExpr:
+		jsondata = map[string]interface{}{
+			"type":  "SpreadExpr",
+			"value": convert(&e.Value),
+		}
+	case expression.ObjectPattern:
+		jsondata = map[string]interface{}{
+			"type":       "ObjectPattern",
+			"properties": patternElementsToJson(e.Properties),
+			"rest":       nil,
+		}
+		if e.Rest != nil {
+			jsondata["rest"] = convert(&e.Rest)
+		}
+	case expression.ArrayPattern:
+		jsondata = map[string]interface{}{
+			"type":     "ArrayPattern",
+			"elements": patternElementsToJson(e.Elements),
+			"rest":     nil,
+		}
+		if e.Rest != nil {
+			jsondata["rest"] = convert(&e.Rest)
+		}
+	case expression.ImportExpr:
+		names := make([]map[string]interface{}, len(e.Names))
+		for index, name := range e.Names {
+			names[index] = map[string]interface{}{
+				"name":  name.Name,
+				"alias": name.Alias,
+			}
+		}
+		jsondata = map[string]interface{}{
+			"type":  "ImportExpr",
+			"names": names,
+			"path":  e.Path,
+		}
+	case expression.ExportExpr:
+		jsondata = map[string]interface{}{
+			"type":  "ExportExpr",
+			"decl":  nil,
+			"names": e.Names,
+		}
+		if e.Decl != nil {
+			jsondata["decl"] = convert(&e.Decl)
+		}
+	case expression.MatchExpr:
+		arms := make([]map[string]interface{}, len(e.Arms))
+		for index, arm := range e.Arms {
+			arms[index] = map[string]interface{}{
+				"pattern": convert(&arm.Pattern),
+				"guard":   nil,
+				"body":    ExpressionToJson(&arm.Body),
+			}
+			if arm.Guard != nil {
+				arms[index]["guard"] = convert(&arm.Guard)
+			}
+		}
+		jsondata = map[string]interface{}{
+			"type":    "MatchExpr",
+			"subject": convert(&e.Subject),
+			"arms":    arms,
+		}
+	case expression.WildcardPattern:
+		jsondata = map[string]interface{}{
+			"type": "WildcardPattern",
+		}
+	case expression.BindingPattern:
+		jsondata = map[string]interface{}{
+			"type": "BindingPattern",
+			"name": e.Name,
+		}
+	case expression.TypePattern:
+		jsondata = map[string]interface{}{
+			"type":     "TypePattern",
+			"typeName": e.Type,
+		}
+	case expression.RangePattern:
+		jsondata = map[string]interface{}{
+			"type": "RangePattern",
+			"low":  convert(&e.Low),
+			"high": convert(&e.High),
+		}
+	case expression.ShapePattern:
+		props := make([]map[string]interface{}, len(e.Properties))
+		for index, prop := range e.Properties {
+			props[index] = map[string]interface{}{
+				"key":     prop.Key,
+				"pattern": nil,
+			}
+			if prop.Pattern != nil {
+				props[index]["pattern"] = convert(&prop.Pattern)
+			}
+		}
+		jsondata = map[string]interface{}{
+			"type":       "ShapePattern",
+			"properties": props,
+		}
 
 	default:
 		jsondata = map[string]interface{}{
@@ -135,3 +253,18 @@ func convert(expr *expression.Expr) *map[string]interface{} {
 	}
 	return &jsondata
 }
+
+func patternElementsToJson(elements []expression.PatternElement) []map[string]interface{} {
+	jsondata := make([]map[string]interface{}, len(elements))
+	for index, element := range elements {
+		jsondata[index] = map[string]interface{}{
+			"key":     element.Key,
+			"target":  convert(&element.Target),
+			"default": nil,
+		}
+		if element.Default != nil {
+			jsondata[index]["default"] = convert(&element.Default)
+		}
+	}
+	return jsondata
+}
@@ -1,7 +1,6 @@
 package helper
 
 import (
-	"theparadance.com/quan-lang/src/object"
 	"theparadance.com/quan-lang/src/token"
 )
 
@@ -75,6 +74,22 @@ func CompareStrings(a, b string, op token.TokenType) int {
 		if a != b {
 			return 1
 		}
+	case token.TokenLT:
+		if a < b {
+			return 1
+		}
+	case token.TokenLE:
+		if a <= b {
+			return 1
+		}
+	case token.TokenGT:
+		if a > b {
+			return 1
+		}
+	case token.TokenGE:
+		if a >= b {
+			return 1
+		}
 	default:
 		panic("Unsupported string comparison operator")
 	}
@@ -97,18 +112,18 @@ func CompareBools(a, b bool, op token.TokenType) int {
 	return 0
 }
 
-func CompareNulls(a, b *object.Null, op token.TokenType) int {
+// CompareNulls compares two values of which at least one is null. null is
+// only equal to null, and ordering comparisons involving null are false.
+func CompareNulls(aIsNull, bIsNull bool, op token.TokenType) int {
 	switch op {
 	case token.TokenEqual:
-		if a == b {
+		if aIsNull == bIsNull {
 			return 1
 		}
 	case token.TokenNE:
-		if a != b {
+		if aIsNull != bIsNull {
 			return 1
 		}
-	default:
-		panic("Unsupported bool comparison operator")
 	}
 	return 0
 }
@@ -0,0 +1,324 @@
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"theparadance.com/quan-lang/src/array"
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// Array methods callable from scripts, e.g. `arr.map(x => x * 2)`.
+//
+// map, filter, slice and concat return a new array and leave the receiver
+// untouched. push, pop, shift, unshift, sort and reverse modify the receiver
+// in place, so the change is seen through every variable, property and
+// element holding the array.
+
+func callArrayMethod(arr *object.Array, member expression.MemberExpr, args []interface{}, env *environment.Env) interface{} {
+	return CallArrayMethod(arr, member.Property, args, func(fn interface{}, args ...interface{}) interface{} {
+		return CallCallback(fn, env, args...)
+	}, env.Limits)
+}
+
+// Caller calls a function value with arguments on behalf of an array method.
+// Callbacks may declare fewer parameters than they are passed.
+type Caller func(fn interface{}, args ...interface{}) interface{}
+
+// CallArrayMethod runs an array method and accounts for the memory it
+// allocates in limits. Methods whose result can be much larger than arr
+// reserve its memory before building it.
+func CallArrayMethod(arr *object.Array, name string, args []interface{}, call Caller, limits *environment.Limits) interface{} {
+	length := arr.Len()
+	result := arrayMethod(arr, array.NewArray(&arr.Items), name, args, call, limits)
+	allocArrayMethod(limits, name, length, arr, result)
+	return result
+}
+
+func arrayMethod(arr *object.Array, a *array.Array[interface{}], name string, args []interface{}, call Caller, limits *environment.Limits) interface{} {
+	switch name {
+	case "map":
+		fn := callbackArg(name, args, 0)
+		return object.NewArray(a.Map(func(item interface{}, index int) any {
+			return call(fn, item, index)
+		}))
+	case "filter":
+		fn := callbackArg(name, args, 0)
+		return object.NewArray(a.Filter(func(item interface{}, index int) bool {
+			return IsTruthy(call(fn, item, index))
+		}))
+	case "reduce":
+		fn := callbackArg(name, args, 0)
+		if len(args) > 1 {
+			return a.Reduce(func(acc any, item interface{}, index int) any {
+				return call(fn, acc, item, index)
+			}, args[1])
+		}
+		// without an initial value the first element seeds the accumulator
+		if a.Length() == 0 {
+			panic("reduce() of empty array with no initial value")
+		}
+		first, _ := a.Get(0)
+		return a.Reduce(func(acc any, item interface{}, index int) any {
+			if index == 0 {
+				return acc
+			}
+			return call(fn, acc, item, index)
+		}, first)
+	case "forEach":
+		fn := callbackArg(name, args, 0)
+		a.ForEach(func(item *interface{}, index int) {
+			call(fn, *item, index)
+		})
+		return Null
+	case "find":
+		fn := callbackArg(name, args, 0)
+		if item, ok := a.Find(func(item interface{}, index int) bool {
+			return IsTruthy(call(fn, item, index))
+		}); ok {
+			return item
+		}
+		return Null
+	case "findIndex":
+		fn := callbackArg(name, args, 0)
+		return a.FindIndex(func(item interface{}, index int) bool {
+			return IsTruthy(call(fn, item, index))
+		})
+	case "some":
+		fn := callbackArg(name, args, 0)
+		return a.Some(func(item interface{}, index int) bool {
+			return IsTruthy(call(fn, item, index))
+		})
+	case "every":
+		fn := callbackArg(name, args, 0)
+		return a.Every(func(item interface{}, index int) bool {
+			return IsTruthy(call(fn, item, index))
+		})
+	case "indexOf":
+		target := valueArg(name, args, 0)
+		return a.FindIndex(func(item interface{}, index int) bool {
+			return valuesEqual(item, target)
+		})
+	case "lastIndexOf":
+		target := valueArg(name, args, 0)
+		items := a.ToArray()
+		for i := len(items) - 1; i >= 0; i-- {
+			if valuesEqual(items[i], target) {
+				return i
+			}
+		}
+		return -1
+	case "includes":
+		target := valueArg(name, args, 0)
+		return a.Some(func(item interface{}, index int) bool {
+			return valuesEqual(item, target)
+		})
+	case "join":
+		separator := ","
+		if len(args) > 0 {
+			s, ok := args[0].(string)
+			if !ok {
+				panic("join() separator must be a string")
+			}
+			separator = s
+		}
+		items := a.ToArray()
+		if len(items) > 1 {
+			limits.AllocBytes(object.MulSize(int64(len(separator)), int64(len(items)-1)))
+		}
+		limits.AllocBytes(object.StringSize(0))
+		// each part is charged before the next one is built, and nested
+		// arrays and objects as their text grows
+		parts := make([]string, len(items))
+		for i, item := range items {
+			switch item.(type) {
+			case *object.Array, *object.Object:
+				var buf bytes.Buffer
+				printer := object.Printer{Reserve: limits.AllocBytes}
+				printer.Print(&buf, item)
+				parts[i] = buf.String()
+			default:
+				parts[i] = array.ToString(item)
+				limits.AllocBytes(int64(len(parts[i])))
+			}
+		}
+		return strings.Join(parts, separator)
+	case "slice":
+		start, end := 0, a.Length()
+		if len(args) > 0 {
+			start = sliceBound(args[0], a.Length())
+		}
+		if len(args) > 1 {
+			end = sliceBound(args[1], a.Length())
+		}
+		part := a.Slice(start, end)
+		copied := make([]interface{}, len(part))
+		copy(copied, part)
+		return object.NewArray(copied)
+	case "concat":
+		length := a.Length()
+		for _, arg := range args {
+			if other, ok := arg.(*object.Array); ok {
+				length += other.Len()
+			} else {
+				length++
+			}
+		}
+		limits.AllocBytes(object.ArraySize(int64(length)))
+		result := make([]interface{}, 0, length)
+		result = append(result, a.ToArray()...)
+		for _, arg := range args {
+			if other, ok := arg.(*object.Array); ok {
+				result = append(result, other.Items...)
+			} else {
+				result = append(result, arg)
+			}
+		}
+		return object.NewArray(result)
+	case "push":
+		for _, arg := range args {
+			a.Push(arg)
+		}
+		return a.Length()
+	case "pop":
+		if item, ok := a.Pop(); ok {
+			return item
+		}
+		return Null
+	case "shift":
+		if item, ok := a.Shift(); ok {
+			return item
+		}
+		return Null
+	case "unshift":
+		for i := len(args) - 1; i >= 0; i-- {
+			a.Unshift(args[i])
+		}
+		return a.Length()
+	case "reverse":
+		a.Reverse()
+		return arr
+	case "sort":
+		if len(args) > 0 {
+			fn := callbackArg(name, args, 0)
+			a.Sort(func(x, y interface{}) int {
+				result := call(fn, x, y)
+				switch n := result.(type) {
+				case int:
+					return n
+				case float64:
+					if n > 0 {
+						return 1
+					} else if n < 0 {
+						return -1
+					}
+					return 0
+				default:
+					panic("sort() comparator must return a number")
+				}
+			})
+		} else {
+			a.Sort(compareValues)
+		}
+		return arr
+	default:
+		panic("Unknown array method: " + name)
+	}
+}
+
+func callbackArg(method string, args []interface{}, index int) interface{} {
+	if index >= len(args) {
+		panic(fmt.Sprintf("%s() expects a function argument", method))
+	}
+	switch args[index].(type) {
+	case expression.FuncDef, *environment.Closure, environment.BuiltinFunc, environment.Callable:
+		return args[index]
+	default:
+		panic(fmt.Sprintf("%s() expects a function argument", method))
+	}
+}
+
+func valueArg(method string, args []interface{}, index int) interface{} {
+	if index >= len(args) {
+		panic(fmt.Sprintf("%s() expects %d argument(s)", method, index+1))
+	}
+	return args[index]
+}
+
+// sliceBound converts a slice() argument to an index, counting negative
+// values from the end of the array.
+func sliceBound(val interface{}, length int) int {
+	n := toIndex(val)
+	if n < 0 {
+		n += length
+		if n < 0 {
+			n = 0
+		}
+	}
+	if n > length {
+		n = length
+	}
+	return n
+}
+
+// valuesEqual compares two runtime values, treating int and float64 with the
+// same numeric value as equal.
+func valuesEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int:
+		switch y := b.(type) {
+		case int:
+			return x == y
+		case float64:
+			return float64(x) == y
+		}
+		return false
+	case float64:
+		switch y := b.(type) {
+		case int:
+			return x == float64(y)
+		case float64:
+			return x == y
+		}
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues is the default sort order: numbers numerically, strings
+// lexicographically and everything else by its printed form.
+func compareValues(a, b interface{}) int {
+	switch x := a.(type) {
+	case int, float64:
+		xf := toNumber(x)
+		switch y := b.(type) {
+		case int, float64:
+			yf := toNumber(y)
+			if xf < yf {
+				return -1
+			} else if xf > yf {
+				return 1
+			}
+			return 0
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
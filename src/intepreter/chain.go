@@ -0,0 +1,110 @@
+package interpreter
+
+import (
+	"unicode/utf8"
+
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// evalChain evaluates a member access, index or call together with the
+// chain it belongs to, e.g. `order?.items[0].price`. The second result is
+// true when an optional link (`?.`) met null; the rest of the chain is then
+// skipped and the whole chain evaluates to null.
+func evalChain(expr expression.Expr, env *environment.Env) (interface{}, bool) {
+	switch e := expr.(type) {
+	case expression.MemberExpr:
+		objVal, short := evalChain(e.Object, env)
+		if short || (e.Optional && IsNull(objVal)) {
+			return Null, true
+		}
+		return MemberValue(objVal, e.Property), false
+	case expression.IndexExpr:
+		arrayVal, short := evalChain(e.Array, env)
+		if short || (e.Optional && IsNull(arrayVal)) {
+			return Null, true
+		}
+		indexVal, _ := Eval(e.Index, env)
+		return IndexValue(arrayVal, indexVal), false
+	case expression.CallExpr:
+		// method call on a value: arr.map(fn)
+		if member, ok := e.Callee.(expression.MemberExpr); ok {
+			objVal, short := evalChain(member.Object, env)
+			if short || (member.Optional && IsNull(objVal)) {
+				return Null, true
+			}
+			switch obj := objVal.(type) {
+			case *object.Array:
+				return callArrayMethod(obj, member, evalArgs(e.Args, env), env), false
+			case string:
+				return CallStringMethod(obj, member.Property, evalArgs(e.Args, env), env.Limits), false
+			}
+			callee := MemberValue(objVal, member.Property)
+			if e.Optional && IsNull(callee) {
+				return Null, true
+			}
+			return CallValue(callee, evalArgs(e.Args, env), env), false
+		}
+
+		callee, short := evalChain(e.Callee, env)
+		if short || (e.Optional && IsNull(callee)) {
+			return Null, true
+		}
+		return CallValue(callee, evalArgs(e.Args, env), env), false
+	default:
+		val, _ := Eval(expr, env)
+		return val, false
+	}
+}
+
+// MemberValue reads `objVal.property`.
+func MemberValue(objVal interface{}, property string) interface{} {
+	if obj, ok := objVal.(object.Properties); ok {
+		if val, ok := obj.GetProperty(property); ok {
+			return val
+		}
+		return Null
+	}
+	if property == "length" {
+		switch v := objVal.(type) {
+		case *object.Array:
+			return v.Len()
+		case string:
+			return utf8.RuneCountInString(v)
+		}
+	}
+	if IsNull(objVal) {
+		panic("Cannot read property '" + property + "' of null")
+	}
+	panic("Attempt to access property on non-object")
+}
+
+// IndexValue reads `arrayVal[indexVal]` for arrays, strings and objects.
+func IndexValue(arrayVal interface{}, indexVal interface{}) interface{} {
+	if str, ok := arrayVal.(string); ok {
+		return stringIndex(str, toIndex(indexVal))
+	}
+
+	// dynamic property access: obj[key]
+	if obj, ok := arrayVal.(object.Properties); ok {
+		if val, ok := obj.GetProperty(propertyKey(indexVal)); ok {
+			return val
+		}
+		return Null
+	}
+
+	arr, ok := arrayVal.(*object.Array)
+	if !ok {
+		if IsNull(arrayVal) {
+			panic("Cannot index null")
+		}
+		panic("Trying to index non-array value")
+	}
+
+	indexInt := toIndex(indexVal)
+	if indexInt < 0 || indexInt >= arr.Len() {
+		panic("Array index out of bounds")
+	}
+	return arr.Items[indexInt]
+}
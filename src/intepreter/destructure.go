@@ -0,0 +1,108 @@
+package interpreter
+
+import (
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// destructureObject assigns `{ a, b: c, d = 1, ...rest } = val`. Missing
+// properties are null unless the pattern gives a default.
+func destructureObject(pattern expression.ObjectPattern, val interface{}, env *environment.Env, update bool) {
+	obj, ok := val.(object.Properties)
+	if !ok {
+		if IsNull(val) {
+			panic("Cannot destructure null as an object")
+		}
+		panic("Cannot destructure a non-object value as an object")
+	}
+
+	used := make(map[string]bool, len(pattern.Properties))
+	for _, prop := range pattern.Properties {
+		used[prop.Key] = true
+		propVal, ok := obj.GetProperty(prop.Key)
+		if !ok {
+			propVal = Null
+		}
+		assign(prop.Target, withDefault(propVal, prop.Default, env), env, update)
+	}
+
+	if pattern.Rest != nil {
+		rest := object.NewObject()
+		for _, key := range obj.Keys() {
+			if !used[key] {
+				propVal, _ := obj.GetProperty(key)
+				rest.SetProperty(key, propVal)
+			}
+		}
+		env.Limits.Alloc(rest)
+		assign(pattern.Rest, rest, env, update)
+	}
+}
+
+// destructureArray assigns `[a, b = 1, ...rest] = val`. Elements past the
+// end of the array are null unless the pattern gives a default.
+func destructureArray(pattern expression.ArrayPattern, val interface{}, env *environment.Env, update bool) {
+	arr, ok := val.(*object.Array)
+	if !ok {
+		if IsNull(val) {
+			panic("Cannot destructure null as an array")
+		}
+		panic("Cannot destructure a non-array value as an array")
+	}
+
+	for i, element := range pattern.Elements {
+		var item interface{} = Null
+		if i < arr.Len() {
+			item = arr.Items[i]
+		}
+		assign(element.Target, withDefault(item, element.Default, env), env, update)
+	}
+
+	if pattern.Rest != nil {
+		rest := object.NewArray([]interface{}{})
+		if len(pattern.Elements) < arr.Len() {
+			rest.Items = append(rest.Items, arr.Items[len(pattern.Elements):]...)
+		}
+		env.Limits.Alloc(rest)
+		assign(pattern.Rest, rest, env, update)
+	}
+}
+
+func withDefault(val interface{}, defaultExpr expression.Expr, env *environment.Env) interface{} {
+	if defaultExpr != nil && IsNull(val) {
+		defaultVal, _ := Eval(defaultExpr, env)
+		return defaultVal
+	}
+	return val
+}
+
+// SpreadObject copies the properties of `...val` into obj. Spreading null
+// adds nothing.
+func SpreadObject(obj *object.Object, val interface{}) {
+	switch source := val.(type) {
+	case object.Properties:
+		for _, key := range source.Keys() {
+			propVal, _ := source.GetProperty(key)
+			obj.SetProperty(key, propVal)
+		}
+	default:
+		if !IsNull(val) {
+			panic("Only objects can be spread into an object literal")
+		}
+	}
+}
+
+// SpreadArray returns the elements of `...val` for an array literal.
+// Spreading null adds nothing.
+func SpreadArray(val interface{}) []interface{} {
+	switch source := val.(type) {
+	case *object.Array:
+		return source.Items
+	default:
+		if !IsNull(val) {
+			panic("Only arrays can be spread into an array literal")
+		}
+		return nil
+	}
+}
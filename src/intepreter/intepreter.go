@@ -2,19 +2,20 @@ package interpreter
 
 import (
 	"fmt"
-	"math"
 	"strings"
 
 	environment "theparadance.com/quan-lang/src/env"
 	"theparadance.com/quan-lang/src/expression"
-	"theparadance.com/quan-lang/src/helper"
 	"theparadance.com/quan-lang/src/object"
 	"theparadance.com/quan-lang/src/token"
 )
 
-var Null = &object.Null{}
+var Null = object.NULL
 
 func Eval(expr expression.Expr, env *environment.Env) (interface{}, bool) {
+	if env.Limits != nil {
+		env.Limits.Step()
+	}
 	switch e := expr.(type) {
 	case expression.NullExpr:
 		return Null, false
@@ -34,227 +35,57 @@ func Eval(expr expression.Expr, env *environment.Env) (interface{}, bool) {
 				builder.WriteString(fmt.Sprint(val))
 			}
 		}
-		return builder.String(), false
+		result := builder.String()
+		env.Limits.Alloc(result)
+		return result, false
 	case expression.BooleanExpr:
 		return e.Value, false
 	case expression.VarExpr:
-		val, ok := env.GetVar(e.Name)
-		if !ok {
-			panic("Undefined variable: " + e.Name)
+		return lookupVar(e.Name, env), false
+	case expression.LocalExpr:
+		if val := frameOf(e, env).Slots[e.Slot]; val != environment.Unset {
+			return val, false
 		}
-		return val, false
+		// not assigned yet in this call: the caller's variable is visible
+		return lookupVar(e.Name, env), false
 	case expression.AssignExpr:
 		val, _ := Eval(e.Value, env)
-		switch target := e.Target.(type) {
-		case expression.VarExpr:
-			env.SetVar(target.Name, val)
-		case expression.MemberExpr:
-			objVal, _ := Eval(target.Object, env)
-			if objMap, ok := objVal.(map[string]interface{}); ok {
-				objMap[target.Property] = val
-			} else {
-				panic("Attempt to assign to property on non-object")
-			}
-		default:
-			panic("Invalid assignment target")
-		}
+		assign(e.Target, val, env, false)
 		return val, false
 	case expression.BinaryExpr:
 		leftVal, _ := Eval(e.Left, env)
-		rightVal, _ := Eval(e.Right, env)
 
-		// Helper function: convert interface{} to float64 if possible
-		toFloat := func(v interface{}) (float64, bool) {
-			switch n := v.(type) {
-			case int:
-				return float64(n), true
-			case float64:
-				return n, true
-			default:
-				return 0, false
+		// a ?? b only evaluates b when a is null
+		if e.Operator.Type == token.TokenNullish {
+			if IsNull(leftVal) {
+				return Eval(e.Right, env)
 			}
+			return leftVal, false
 		}
 
-		// Helper function: convert interface{} to int if possible
-		toInt := func(v interface{}) (int, bool) {
-			switch n := v.(type) {
-			case int:
-				return n, true
-			case float64:
-				// Only convert if float64 is integral
-				if n == float64(int(n)) {
-					return int(n), true
-				}
-				return 0, false
-			default:
-				return 0, false
-			}
-		}
-
-		switch e.Operator.Type {
-		case token.TokenPlus:
-			// String concatenation
-			if ls, ok := leftVal.(string); ok {
-				if rs, ok := rightVal.(string); ok {
-					return ls + rs, false
-				}
-			}
-
-			// Fallback to numeric addition
-			lf, lok := toFloat(leftVal)
-			rf, rok := toFloat(rightVal)
-			if !lok || !rok {
-				panic("Plus operator requires both numeric or both string operands")
-			}
-			return lf + rf, false
-		case token.TokenMinus, token.TokenStar, token.TokenSlash, token.TokenCaret:
-			// Arithmetic operators
-			lf, lok := toFloat(leftVal)
-			rf, rok := toFloat(rightVal)
-			if !lok || !rok {
-				panic("Arithmetic operators require numeric types")
-			}
-
-			switch e.Operator.Type {
-			case token.TokenMinus:
-				return lf - rf, false
-			case token.TokenStar:
-				return lf * rf, false
-			case token.TokenSlash:
-				if rf == 0 {
-					panic("Division by zero")
-				}
-				return lf / rf, false
-			case token.TokenCaret:
-				return math.Pow(lf, rf), false
-			}
-
-		case token.TokenMod:
-			// Modulus only for integers
-			li, lok := toInt(leftVal)
-			ri, rok := toInt(rightVal)
-			if !lok || !rok {
-				panic("Modulo operator requires integer operands")
-			}
-			if ri == 0 {
-				panic("Modulo by zero")
-			}
-			return li % ri, false
-
-		case token.TokenEqual, token.TokenNE, token.TokenLT, token.TokenLE, token.TokenGT, token.TokenGE:
-			// Equality & Comparison - support int, float64, string, bool
-
-			switch l := leftVal.(type) {
-			case int:
-				switch r := rightVal.(type) {
-				case int:
-					return helper.CompareInts(l, r, e.Operator.Type), false
-				case float64:
-					return helper.CompareFloats(float64(l), r, e.Operator.Type), false
-				case (*object.Null):
-					return 0, false
-				default:
-					panic("Type mismatch in comparison")
-				}
-			case float64:
-				switch r := rightVal.(type) {
-				case int:
-					return helper.CompareFloats(l, float64(r), e.Operator.Type), false
-				case float64:
-					return helper.CompareFloats(l, r, e.Operator.Type), false
-				case (*object.Null):
-					return 0, false
-				default:
-					panic("Type mismatch in comparison")
-				}
-			case string:
-				switch rs := rightVal.(type) {
-				case string:
-					return helper.CompareStrings(l, rs, e.Operator.Type), false
-				case (*object.Null):
-					return 0, false
-				default:
-					panic("Type mismatch in comparison")
-				}
-			case bool:
-				switch rb := rightVal.(type) {
-				case bool:
-					return helper.CompareBools(l, rb, e.Operator.Type), false
-				case (*object.Null):
-					return 0, false
-				default:
-					panic("Type mismatch in comparison")
-				}
-			case *object.Null:
-				rNull, ok := rightVal.(*object.Null)
-				if !ok {
-					// panic("Type mismatch in comparison")
-					return 0, false
-				}
-				return helper.CompareNulls(l, rNull, e.Operator.Type), false
-			default:
-				panic("Unsupported type for comparison")
-			}
-
-		default:
-			panic("Unknown operator: " + e.Operator.Literal)
-		}
+		rightVal, _ := Eval(e.Right, env)
+		result := BinaryOp(e.Operator, leftVal, rightVal)
+		env.Limits.Alloc(result)
+		return result, false
 	case expression.IfExpr:
 		cond, _ := Eval(e.Condition, env)
 
-		// Handle bool
-		if b, ok := cond.(bool); ok {
-			if b {
-				for _, stmt := range e.Then {
-					val, ret := Eval(stmt, env)
-					if ret {
-						return val, ret
-					}
-				}
-			} else {
-				for _, stmt := range e.Else {
-					val, ret := Eval(stmt, env)
-					if ret {
-						return val, ret
-					}
-				}
-			}
-			return nil, false
+		branch := e.Else
+		if IsTruthy(cond) {
+			branch = e.Then
 		}
-
-		// fallback if condition is int
-		if i, ok := cond.(int); ok && i != 0 {
-			for _, stmt := range e.Then {
-				val, ret := Eval(stmt, env)
-				if ret {
-					return val, ret
-				}
-			}
-		} else {
-			for _, stmt := range e.Else {
-				val, ret := Eval(stmt, env)
-				if ret {
-					return val, ret
-				}
+		for _, stmt := range branch {
+			val, ret := Eval(stmt, env)
+			if ret {
+				return val, ret
 			}
 		}
-		return nil, false
+		return Null, false
+	case expression.MatchExpr:
+		return evalMatch(e, env)
 	case expression.TernaryExpr:
 		condVal, _ := Eval(e.Condition, env)
-		condBool := false
-
-		switch v := condVal.(type) {
-		case bool:
-			condBool = v
-		case int:
-			condBool = v != 0
-		case float64:
-			condBool = v != 0.0
-		default:
-			panic("Invalid condition type for ternary expression")
-		}
-
-		if condBool {
+		if IsTruthy(condVal) {
 			return Eval(e.TrueValue, env)
 		} else {
 			return Eval(e.FalseValue, env)
@@ -265,7 +96,7 @@ func Eval(expr expression.Expr, env *environment.Env) (interface{}, bool) {
 			return e, false
 		}
 
-		env.Funcs[e.Name] = e
+		env.SetFunc(e.Name, e)
 		return 0, false
 	case expression.FuncCall:
 		// 1. Try user-defined function
@@ -273,35 +104,18 @@ func Eval(expr expression.Expr, env *environment.Env) (interface{}, bool) {
 			if len(e.Args) != len(fn.Params) {
 				panic(fmt.Sprintf("Function %s expects %d args, got %d", e.Name, len(fn.Params), len(e.Args)))
 			}
-			// Prepare local environment
-			localEnv := environment.NewEnv(env)
-			for i, param := range fn.Params {
-				argVal, _ := Eval(e.Args[i], env)
-				localEnv.SetVar(param, argVal)
-			}
-
-			for _, stmt := range fn.Body {
-				val, ret := Eval(stmt, localEnv)
-				if ret {
-					return val, true
-				}
-			}
-			return 0, false
+			return CallFunction(fn, evalArgs(e.Args, env), env), false
 		}
 
 		// 2. Try built-in function
 		if builtin, ok := env.GetBuiltin(e.Name); ok {
-			var args []interface{}
-			for _, argExpr := range e.Args {
-				argVal, _ := Eval(argExpr, env)
-				args = append(args, argVal)
-			}
-			println("try calling builtin function")
-			result, err := builtin(args)
+			result, err := builtin(evalArgs(e.Args, env))
 			if err != nil {
 				panic(err)
 			}
-			return result, false
+			val := object.FromGo(result)
+			env.Limits.AllocDeep(val)
+			return val, false
 		}
 
 		// 3. Try function from a variable
@@ -310,89 +124,300 @@ func Eval(expr expression.Expr, env *environment.Env) (interface{}, bool) {
 				if len(e.Args) != len(fnExpr.Params) {
 					panic(fmt.Sprintf("Function expects %d args, got %d", len(fnExpr.Params), len(e.Args)))
 				}
-
-				localEnv := environment.NewEnv(env)
-				for i, param := range fnExpr.Params {
-					argVal, _ := Eval(e.Args[i], env)
-					localEnv.SetVar(param, argVal)
-				}
-
-				for _, stmt := range fnExpr.Body {
-					val, ret := Eval(stmt, localEnv)
-					if ret {
-						return val, true
-					}
-				}
-				return 0, false
-			} else {
-				panic("Variable is not a function")
+				return CallFunction(fnExpr, evalArgs(e.Args, env), env), false
+			}
+			switch val.(type) {
+			case environment.BuiltinFunc, *environment.Closure, environment.Callable:
+				return CallValue(val, evalArgs(e.Args, env), env), false
 			}
+			panic("Variable is not a function")
 		}
 		panic("Function not found: " + e.Name)
+	case expression.CallExpr:
+		val, _ := evalChain(e, env)
+		return val, false
 	case expression.ReturnExpr:
 		if e.Value == nil {
-			return nil, true
+			return Null, true
 		}
 		val, _ := Eval(e.Value, env)
 		return val, true
 	case expression.ObjectExpr:
-		obj := make(map[string]interface{})
-		for k, vExpr := range e.Pairs {
-			v, _ := Eval(vExpr, env)
-			obj[k] = v
+		obj := object.NewObject()
+		for _, pair := range e.Pairs {
+			if spread, ok := pair.Value.(expression.SpreadExpr); ok {
+				val, _ := Eval(spread.Value, env)
+				SpreadObject(obj, val)
+				continue
+			}
+			v, _ := Eval(pair.Value, env)
+			obj.SetProperty(pair.Key, v)
 		}
+		env.Limits.Alloc(obj)
 		return obj, false
 	// object Member access evaluation: a.x
 	case expression.MemberExpr:
-		objVal, _ := Eval(e.Object, env)
-		if objMap, ok := objVal.(map[string]interface{}); ok {
-			return objMap[e.Property], false
-		}
-		panic("Attempt to access property on non-object")
+		val, _ := evalChain(e, env)
+		return val, false
 	case expression.ArrayExpr:
 		var result []interface{}
 		for _, elem := range e.Elements {
+			if spread, ok := elem.(expression.SpreadExpr); ok {
+				val, _ := Eval(spread.Value, env)
+				result = append(result, SpreadArray(val)...)
+				continue
+			}
 			val, _ := Eval(elem, env)
 			result = append(result, val)
 		}
-		return result, false
+		arr := object.NewArray(result)
+		env.Limits.Alloc(arr)
+		return arr, false
 	case expression.IndexExpr:
-		arrayVal, _ := Eval(e.Array, env)
-		indexVal, _ := Eval(e.Index, env)
+		val, _ := evalChain(e, env)
+		return val, false
+	case expression.ImportExpr:
+		evalImport(e, env)
+		return Null, false
+	case expression.ExportExpr:
+		evalExport(e, env)
+		return Null, false
+	default:
+		panic("Unknown expression type")
+	}
+}
 
-		arr, ok := arrayVal.([]interface{})
-		if !ok {
-			panic("Trying to index non-array value")
-		}
+func lookupVar(name string, env *environment.Env) interface{} {
+	val, ok := env.GetVar(name)
+	if ok {
+		return val
+	}
+	// named functions and builtins can be passed around as values
+	if fn, ok := env.GetFunc(name); ok {
+		return fn
+	}
+	if builtin, ok := env.GetBuiltin(name); ok {
+		return builtin
+	}
+	panic("Undefined variable: " + name)
+}
+
+// frameOf returns the function frame holding a local's slot.
+func frameOf(local expression.LocalExpr, env *environment.Env) *environment.Env {
+	for depth := local.Depth; depth > 0; depth-- {
+		env = env.Parent
+	}
+	return env
+}
+
+func evalArgs(argExprs []expression.Expr, env *environment.Env) []interface{} {
+	args := make([]interface{}, 0, len(argExprs))
+	for _, argExpr := range argExprs {
+		argVal, _ := Eval(argExpr, env)
+		args = append(args, argVal)
+	}
+	return args
+}
 
-		var indexInt int
-		switch v := indexVal.(type) {
-		case int:
-			indexInt = v
-		case float64:
-			if v == float64(int(v)) {
-				indexInt = int(v)
+// CallFunction runs a user function with already evaluated arguments in a
+// new scope whose parent is env, and returns the function's return value.
+func CallFunction(fn expression.FuncDef, args []interface{}, env *environment.Env) interface{} {
+	var localEnv *environment.Env
+	if fn.Scope != nil {
+		// parameters occupy the first slots of a resolved function
+		localEnv = environment.NewFrame(env, fn.Scope)
+		for i := range fn.Params {
+			if i < len(args) {
+				localEnv.Slots[i] = args[i]
 			} else {
-				panic("Array index must be an integer")
+				localEnv.Slots[i] = Null
 			}
-		default:
-			panic("Array index must be an integer")
 		}
+	} else {
+		localEnv = environment.NewEnv(env)
+		for i, param := range fn.Params {
+			if i < len(args) {
+				localEnv.SetVar(param, args[i])
+			} else {
+				localEnv.SetVar(param, Null)
+			}
+		}
+	}
+	if localEnv.Limits != nil {
+		localEnv.Limits.Enter()
+		defer localEnv.Limits.Leave()
+	}
+
+	for _, stmt := range fn.Body {
+		val, ret := Eval(stmt, localEnv)
+		if ret {
+			return val
+		}
+	}
+	return Null
+}
+
+// CallValue calls a runtime function value, either a user function or a builtin.
+func CallValue(callee interface{}, args []interface{}, env *environment.Env) interface{} {
+	switch fn := callee.(type) {
+	case expression.FuncDef:
+		if len(args) != len(fn.Params) {
+			panic(fmt.Sprintf("Function expects %d args, got %d", len(fn.Params), len(args)))
+		}
+		return CallFunction(fn, args, env)
+	case *environment.Closure:
+		if len(args) != len(fn.Func.Params) {
+			panic(fmt.Sprintf("Function expects %d args, got %d", len(fn.Func.Params), len(args)))
+		}
+		return CallFunction(fn.Func, args, fn.Env)
+	case environment.BuiltinFunc:
+		result, err := fn(args)
+		if err != nil {
+			panic(err)
+		}
+		val := object.FromGo(result)
+		env.Limits.AllocDeep(val)
+		return val
+	case environment.Callable:
+		return CallValue(fn.Definition(), args, env)
+	default:
+		panic("Value is not a function")
+	}
+}
+
+// CallCallback calls fn with as many of args as it declares parameters, so
+// callbacks like `x => x * 2` can ignore the trailing index argument.
+func CallCallback(fn interface{}, env *environment.Env, args ...interface{}) interface{} {
+	switch def := fn.(type) {
+	case expression.FuncDef:
+		if len(args) > len(def.Params) {
+			args = args[:len(def.Params)]
+		}
+		return CallFunction(def, args, env)
+	case *environment.Closure:
+		if len(args) > len(def.Func.Params) {
+			args = args[:len(def.Func.Params)]
+		}
+		return CallFunction(def.Func, args, def.Env)
+	case environment.Callable:
+		return CallCallback(def.Definition(), env, args...)
+	}
+	return CallValue(fn, args, env)
+}
+
+// assign stores val into an assignable target. When update is true a
+// variable is written in the scope that already defines it instead of the
+// current scope.
+func assign(targetExpr expression.Expr, val interface{}, env *environment.Env, update bool) {
+	switch target := targetExpr.(type) {
+	case expression.VarExpr:
+		if !update || !env.UpdateVar(target.Name, val) {
+			env.SetVar(target.Name, val)
+		}
+	case expression.LocalExpr:
+		frame := frameOf(target, env)
+		if !update || frame.Slots[target.Slot] != environment.Unset || !env.UpdateVar(target.Name, val) {
+			frame.Slots[target.Slot] = val
+		}
+	case expression.MemberExpr:
+		objVal, _ := Eval(target.Object, env)
+		AllocProperty(env.Limits, objVal, target.Property)
+		SetMember(objVal, target.Property, val)
+	case expression.IndexExpr:
+		arrayVal, _ := Eval(target.Array, env)
+		indexVal, _ := Eval(target.Index, env)
+		AllocProperty(env.Limits, arrayVal, indexVal)
+		SetIndex(arrayVal, indexVal, val)
+	case expression.ObjectPattern:
+		destructureObject(target, val, env, update)
+	case expression.ArrayPattern:
+		destructureArray(target, val, env, update)
+	default:
+		panic("Invalid assignment target")
+	}
+}
 
-		if indexInt < 0 || indexInt >= len(arr) {
-			panic("Array index out of bounds")
+// SetMember implements `objVal.property = val`.
+func SetMember(objVal interface{}, property string, val interface{}) {
+	if host, ok := objVal.(*environment.HostObject); ok {
+		if err := host.SetProperty(property, val); err != nil {
+			panic(err)
 		}
+		return
+	}
+	obj, ok := objVal.(*object.Object)
+	if !ok {
+		panic("Attempt to assign to property on non-object")
+	}
+	obj.SetProperty(property, val)
+}
 
-		return arr[indexInt], false
+// SetIndex implements `arrayVal[indexVal] = val` for arrays and objects.
+func SetIndex(arrayVal interface{}, indexVal interface{}, val interface{}) {
+	if obj, ok := arrayVal.(*object.Object); ok {
+		obj.SetProperty(propertyKey(indexVal), val)
+		return
+	}
+	if _, ok := arrayVal.(*environment.HostObject); ok {
+		SetMember(arrayVal, propertyKey(indexVal), val)
+		return
+	}
+	arr, ok := arrayVal.(*object.Array)
+	if !ok {
+		panic("Trying to index non-array value")
+	}
+	indexInt := toIndex(indexVal)
+	if indexInt < 0 || indexInt >= arr.Len() {
+		panic("Array index out of bounds")
+	}
+	arr.Items[indexInt] = val
+}
 
-		if indexInt < 0 || indexInt >= len(arr) {
-			panic("Array index out of bounds")
+func propertyKey(keyVal interface{}) string {
+	key, ok := keyVal.(string)
+	if !ok {
+		panic("Object key must be a string")
+	}
+	return key
+}
+
+func toIndex(indexVal interface{}) int {
+	switch v := indexVal.(type) {
+	case int:
+		return v
+	case float64:
+		if v == float64(int(v)) {
+			return int(v)
 		}
+	}
+	panic("Array index must be an integer")
+}
 
-		return arr[indexInt], false
+// IsNull reports whether val is the runtime null. Go nil coming from host
+// code is treated as null as well.
+func IsNull(val interface{}) bool {
+	switch val.(type) {
+	case nil, *object.Null:
+		return true
 	default:
-		panic("Unknown expression type")
+		return false
 	}
+}
 
-	return 0, false
+// IsTruthy reports whether a runtime value counts as true in a condition.
+func IsTruthy(val interface{}) bool {
+	switch v := val.(type) {
+	case nil, *object.Null:
+		return false
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case float64:
+		return v != 0.0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
 }
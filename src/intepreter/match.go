@@ -0,0 +1,141 @@
+package interpreter
+
+import (
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// evalMatch evaluates the first arm whose pattern matches the subject and
+// whose guard is truthy. Names bound by the pattern live in a scope of their
+// own, visible to the guard and the arm body only. Without a matching arm the
+// result is null.
+func evalMatch(e expression.MatchExpr, env *environment.Env) (interface{}, bool) {
+	subject, _ := Eval(e.Subject, env)
+
+	for _, arm := range e.Arms {
+		armEnv := environment.NewEnv(env)
+		if !matchPattern(arm.Pattern, subject, armEnv) {
+			continue
+		}
+		if arm.Guard != nil {
+			guard, _ := Eval(arm.Guard, armEnv)
+			if !IsTruthy(guard) {
+				continue
+			}
+		}
+
+		var result interface{} = Null
+		for _, stmt := range arm.Body {
+			val, ret := Eval(stmt, armEnv)
+			if ret {
+				return val, true
+			}
+			result = val
+		}
+		return result, false
+	}
+	return Null, false
+}
+
+func matchPattern(pattern expression.Expr, val interface{}, env *environment.Env) bool {
+	switch pat := pattern.(type) {
+	case expression.WildcardPattern:
+		return true
+	case expression.BindingPattern:
+		env.SetVar(pat.Name, val)
+		return true
+	case expression.TypePattern:
+		return matchType(pat.Type, val)
+	case expression.RangePattern:
+		n, ok := numberValue(val)
+		if !ok {
+			return false
+		}
+		lowVal, _ := Eval(pat.Low, env)
+		highVal, _ := Eval(pat.High, env)
+		low, lowOk := numberValue(lowVal)
+		high, highOk := numberValue(highVal)
+		if !lowOk || !highOk {
+			panic("Range pattern bounds must be numbers")
+		}
+		return n >= low && n <= high
+	case expression.ShapePattern:
+		obj, ok := val.(object.Properties)
+		if !ok {
+			return false
+		}
+		for _, prop := range pat.Properties {
+			propVal, exists := obj.GetProperty(prop.Key)
+			if !exists {
+				return false
+			}
+			if prop.Pattern == nil {
+				if IsNull(propVal) {
+					return false
+				}
+				env.SetVar(prop.Key, propVal)
+				continue
+			}
+			if !matchPattern(prop.Pattern, propVal, env) {
+				return false
+			}
+		}
+		return true
+	default:
+		// literal pattern
+		literal, _ := Eval(pattern, env)
+		if IsNull(literal) {
+			return IsNull(val)
+		}
+		return valuesEqual(literal, val)
+	}
+}
+
+func matchType(typeName string, val interface{}) bool {
+	switch typeName {
+	case "int":
+		_, ok := val.(int)
+		if f, isFloat := val.(float64); isFloat {
+			// number literals are floats; whole values count as int
+			return f == float64(int(f))
+		}
+		return ok
+	case "float":
+		_, ok := val.(float64)
+		return ok
+	case "number":
+		_, ok := numberValue(val)
+		return ok
+	case "string":
+		_, ok := val.(string)
+		return ok
+	case "bool":
+		_, ok := val.(bool)
+		return ok
+	case "array":
+		_, ok := val.(*object.Array)
+		return ok
+	case "object":
+		_, ok := val.(object.Properties)
+		return ok
+	case "function":
+		switch val.(type) {
+		case expression.FuncDef, *environment.Closure, environment.BuiltinFunc, environment.Callable:
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+func numberValue(val interface{}) (float64, bool) {
+	switch n := val.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,76 @@
+package interpreter
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// allocArrayMethod accounts for the memory an array method allocated: the
+// new array it returned, or the elements it added to arr, which held length
+// elements before the call. concat and join reserve their result
+// themselves, see CallArrayMethod.
+func allocArrayMethod(limits *environment.Limits, name string, length int, arr *object.Array, result interface{}) {
+	switch name {
+	case "map", "filter", "slice":
+		limits.Alloc(result)
+	case "push", "unshift":
+		limits.AllocBytes(object.ElementSize * int64(arr.Len()-length))
+	}
+}
+
+// AllocProperty accounts for a property about to be added to an object.
+func AllocProperty(limits *environment.Limits, objVal interface{}, key interface{}) {
+	obj, ok := objVal.(*object.Object)
+	if !ok {
+		return
+	}
+	if name, ok := key.(string); ok && !obj.HasProperty(name) {
+		limits.AllocBytes(object.PropertySize(name))
+	}
+}
+
+// stringMethodSize computes the memory taken by the result of the string
+// methods whose result can be much larger than the receiver, from the
+// receiver and the arguments. ok is false for other methods.
+func stringMethodSize(s string, name string, args []interface{}) (size int64, ok bool) {
+	switch name {
+	case "repeat":
+		count := toIndex(valueArg(name, args, 0))
+		if count < 0 {
+			panic("repeat() count must not be negative")
+		}
+		return object.StringSize(object.MulSize(int64(len(s)), int64(count))), true
+	case "padStart", "padEnd":
+		width := toIndex(valueArg(name, args, 0))
+		pad := " "
+		if len(args) > 1 {
+			pad = stringArg(name, args, 1)
+		}
+		missing := width - utf8.RuneCountInString(s)
+		if missing <= 0 || pad == "" {
+			return 0, true
+		}
+		return object.StringSize(object.AddSize(int64(len(s)), paddingLength(pad, missing))), true
+	case "split":
+		separator := stringArg(name, args, 0)
+		parts := utf8.RuneCountInString(s)
+		if separator != "" {
+			parts = strings.Count(s, separator) + 1
+		}
+		// the parts share the bytes of s
+		return object.ArraySize(int64(parts)), true
+	case "replace", "replaceAll":
+		old, replacement := stringArg(name, args, 0), stringArg(name, args, 1)
+		count := strings.Count(s, old)
+		if name == "replace" && count > 1 {
+			count = 1
+		}
+		growth := object.MulSize(int64(count), int64(len(replacement)))
+		return object.StringSize(object.AddSize(int64(len(s))-int64(count*len(old)), growth)), true
+	default:
+		return 0, false
+	}
+}
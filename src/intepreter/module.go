@@ -0,0 +1,109 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	environment "theparadance.com/quan-lang/src/env"
+	errorexception "theparadance.com/quan-lang/src/error-exception"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/resolver"
+)
+
+// evalImport loads a module through the registry of the enclosing program
+// and binds the requested exports in the current scope.
+func evalImport(e expression.ImportExpr, env *environment.Env) {
+	scope := env.ModuleScope()
+	if scope == nil {
+		panic(&errorexception.ModuleError{
+			Message: fmt.Sprintf("Cannot import %q: no module loader is configured", e.Path),
+		})
+	}
+
+	mod, err := scope.Modules.Import(scope.ModuleName, e.Path, func(name string, resolved resolver.Result) map[string]interface{} {
+		return runModule(name, resolved, scope)
+	})
+	if err != nil {
+		panic(&errorexception.ModuleError{
+			Message: fmt.Sprintf("Cannot import %q: %s", e.Path, err.Error()),
+		})
+	}
+
+	for _, name := range e.Names {
+		val, ok := mod.Exports[name.Name]
+		if !ok {
+			panic(&errorexception.ModuleError{
+				Message: fmt.Sprintf("Module %q has no export named %s", e.Path, name.Name),
+			})
+		}
+		env.SetVar(name.Alias, val)
+	}
+}
+
+// evalExport runs the exported declaration and records the exported names
+// on the scope. Exports are only collected from a module's top level.
+func evalExport(e expression.ExportExpr, env *environment.Env) {
+	switch decl := e.Decl.(type) {
+	case expression.FuncDef:
+		Eval(decl, env)
+		env.Exports = append(env.Exports, decl.Name)
+	case expression.AssignExpr:
+		target, ok := decl.Target.(expression.VarExpr)
+		if !ok {
+			panic(&errorexception.ModuleError{
+				Message: "export is only allowed at the top level of a module",
+			})
+		}
+		Eval(decl, env)
+		env.Exports = append(env.Exports, target.Name)
+	default:
+		env.Exports = append(env.Exports, e.Names...)
+	}
+}
+
+// runModule executes a module in its own top-level scope, which sees the
+// host variables and builtins but not the importer's variables, and
+// returns its exports. Exported functions become closures over that scope.
+func runModule(name string, resolved resolver.Result, importer *environment.Env) map[string]interface{} {
+	modEnv := environment.NewEnv(importer.Parent)
+	modEnv.Modules = importer.Modules
+	modEnv.ModuleName = name
+	modEnv.Limits = importer.Limits
+
+	undefined := resolved.Undefined(func(name string) bool {
+		_, isVar := modEnv.GetVar(name)
+		_, isFunc := modEnv.GetFunc(name)
+		_, isBuiltin := modEnv.GetBuiltin(name)
+		return isVar || isFunc || isBuiltin
+	})
+	if len(undefined) > 0 {
+		panic(&errorexception.ModuleError{
+			Message: fmt.Sprintf("Module %q uses undefined variable: %s", name, strings.Join(undefined, ", ")),
+		})
+	}
+
+	for _, stmt := range resolved.AST {
+		if _, ret := Eval(stmt, modEnv); ret {
+			break
+		}
+	}
+
+	exports := make(map[string]interface{}, len(modEnv.Exports))
+	for _, export := range modEnv.Exports {
+		if fn, ok := modEnv.Funcs[export]; ok {
+			exports[export] = &environment.Closure{Func: fn, Env: modEnv}
+			continue
+		}
+		val, ok := modEnv.Vars[export]
+		if !ok {
+			panic(&errorexception.ModuleError{
+				Message: fmt.Sprintf("Module %q exports undefined name %s", name, export),
+			})
+		}
+		if fn, ok := val.(expression.FuncDef); ok {
+			val = &environment.Closure{Func: fn, Env: modEnv}
+		}
+		exports[export] = val
+	}
+	return exports
+}
@@ -0,0 +1,162 @@
+package interpreter
+
+import (
+	"math"
+
+	"theparadance.com/quan-lang/src/helper"
+	"theparadance.com/quan-lang/src/object"
+	"theparadance.com/quan-lang/src/token"
+)
+
+// BinaryOp applies a binary operator to two evaluated operands. `??` is not
+// handled here because it must not evaluate its right operand eagerly.
+func BinaryOp(op token.Token, leftVal interface{}, rightVal interface{}) interface{} {
+	// Helper function: convert interface{} to float64 if possible
+	toFloat := func(v interface{}) (float64, bool) {
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case float64:
+			return n, true
+		default:
+			return 0, false
+		}
+	}
+
+	// Helper function: convert interface{} to int if possible
+	toInt := func(v interface{}) (int, bool) {
+		switch n := v.(type) {
+		case int:
+			return n, true
+		case float64:
+			// Only convert if float64 is integral
+			if n == float64(int(n)) {
+				return int(n), true
+			}
+			return 0, false
+		default:
+			return 0, false
+		}
+	}
+
+	switch op.Type {
+	case token.TokenPlus:
+		// String concatenation
+		if ls, ok := leftVal.(string); ok {
+			if rs, ok := rightVal.(string); ok {
+				return ls + rs
+			}
+		}
+
+		// Fallback to numeric addition
+		lf, lok := toFloat(leftVal)
+		rf, rok := toFloat(rightVal)
+		if !lok || !rok {
+			panic("Plus operator requires both numeric or both string operands")
+		}
+		return lf + rf
+	case token.TokenMinus, token.TokenStar, token.TokenSlash, token.TokenCaret:
+		// Arithmetic operators
+		lf, lok := toFloat(leftVal)
+		rf, rok := toFloat(rightVal)
+		if !lok || !rok {
+			panic("Arithmetic operators require numeric types")
+		}
+
+		switch op.Type {
+		case token.TokenMinus:
+			return lf - rf
+		case token.TokenStar:
+			return lf * rf
+		case token.TokenSlash:
+			if rf == 0 {
+				panic("Division by zero")
+			}
+			return lf / rf
+		case token.TokenCaret:
+			return math.Pow(lf, rf)
+		}
+
+	case token.TokenIn:
+		// Membership: key in object, element in array
+		switch container := rightVal.(type) {
+		case object.Properties:
+			key, ok := leftVal.(string)
+			if !ok {
+				panic("'in' operator requires a string key for objects")
+			}
+			_, has := container.GetProperty(key)
+			return has
+		case *object.Array:
+			for _, item := range container.Items {
+				if valuesEqual(item, leftVal) {
+					return true
+				}
+			}
+			return false
+		default:
+			panic("'in' operator requires an object or array on the right")
+		}
+
+	case token.TokenMod:
+		// Modulus only for integers
+		li, lok := toInt(leftVal)
+		ri, rok := toInt(rightVal)
+		if !lok || !rok {
+			panic("Modulo operator requires integer operands")
+		}
+		if ri == 0 {
+			panic("Modulo by zero")
+		}
+		return li % ri
+
+	case token.TokenEqual, token.TokenNE, token.TokenLT, token.TokenLE, token.TokenGT, token.TokenGE:
+		// Equality & Comparison - support int, float64, string, bool
+
+		// null is only equal to null; ordering comparisons with null are false
+		if IsNull(leftVal) || IsNull(rightVal) {
+			return helper.CompareNulls(IsNull(leftVal), IsNull(rightVal), op.Type)
+		}
+
+		switch l := leftVal.(type) {
+		case int:
+			switch r := rightVal.(type) {
+			case int:
+				return helper.CompareInts(l, r, op.Type)
+			case float64:
+				return helper.CompareFloats(float64(l), r, op.Type)
+			default:
+				panic("Type mismatch in comparison")
+			}
+		case float64:
+			switch r := rightVal.(type) {
+			case int:
+				return helper.CompareFloats(l, float64(r), op.Type)
+			case float64:
+				return helper.CompareFloats(l, r, op.Type)
+			default:
+				panic("Type mismatch in comparison")
+			}
+		case string:
+			switch rs := rightVal.(type) {
+			case string:
+				return helper.CompareStrings(l, rs, op.Type)
+			default:
+				panic("Type mismatch in comparison")
+			}
+		case bool:
+			switch rb := rightVal.(type) {
+			case bool:
+				return helper.CompareBools(l, rb, op.Type)
+			default:
+				panic("Type mismatch in comparison")
+			}
+		default:
+			panic("Unsupported type for comparison")
+		}
+
+	default:
+		panic("Unknown operator: " + op.Literal)
+	}
+	return 0
+}
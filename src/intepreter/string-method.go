@@ -0,0 +1,142 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/object"
+)
+
+// String methods callable from scripts, e.g. `name.trim().upper()`.
+// Lengths and positions count runes, not bytes, so they agree with `s[i]`.
+// Strings are immutable: every method returns a new value, which is
+// accounted for in limits.
+func CallStringMethod(s string, name string, args []interface{}, limits *environment.Limits) interface{} {
+	if size, ok := stringMethodSize(s, name, args); ok {
+		// the result can be far larger than s, so its memory is reserved
+		// before it is built
+		limits.AllocBytes(size)
+		return stringMethod(s, name, args)
+	}
+	result := stringMethod(s, name, args)
+	limits.Alloc(result)
+	return result
+}
+
+func stringMethod(s string, name string, args []interface{}) interface{} {
+	switch name {
+	case "upper":
+		return strings.ToUpper(s)
+	case "lower":
+		return strings.ToLower(s)
+	case "trim":
+		return strings.TrimSpace(s)
+	case "trimStart":
+		return strings.TrimLeft(s, " \t\r\n")
+	case "trimEnd":
+		return strings.TrimRight(s, " \t\r\n")
+	case "split":
+		parts := strings.Split(s, stringArg(name, args, 0))
+		result := make([]interface{}, len(parts))
+		for i, part := range parts {
+			result[i] = part
+		}
+		return object.NewArray(result)
+	case "replace":
+		return strings.Replace(s, stringArg(name, args, 0), stringArg(name, args, 1), 1)
+	case "replaceAll":
+		return strings.ReplaceAll(s, stringArg(name, args, 0), stringArg(name, args, 1))
+	case "contains", "includes":
+		return strings.Contains(s, stringArg(name, args, 0))
+	case "startsWith":
+		return strings.HasPrefix(s, stringArg(name, args, 0))
+	case "endsWith":
+		return strings.HasSuffix(s, stringArg(name, args, 0))
+	case "indexOf":
+		index := strings.Index(s, stringArg(name, args, 0))
+		if index < 0 {
+			return -1
+		}
+		return utf8.RuneCountInString(s[:index])
+	case "substring":
+		runes := []rune(s)
+		start, end := 0, len(runes)
+		if len(args) > 0 {
+			start = clampIndex(toIndex(args[0]), len(runes))
+		}
+		if len(args) > 1 {
+			end = clampIndex(toIndex(args[1]), len(runes))
+		}
+		if start > end {
+			start, end = end, start
+		}
+		return string(runes[start:end])
+	case "padStart", "padEnd":
+		width := toIndex(valueArg(name, args, 0))
+		pad := " "
+		if len(args) > 1 {
+			pad = stringArg(name, args, 1)
+		}
+		missing := width - utf8.RuneCountInString(s)
+		if missing <= 0 || pad == "" {
+			return s
+		}
+		var builder strings.Builder
+		builder.Grow(int(object.AddSize(int64(len(s)), paddingLength(pad, missing))))
+		if name == "padEnd" {
+			builder.WriteString(s)
+		}
+		padRunes := utf8.RuneCountInString(pad)
+		builder.WriteString(strings.Repeat(pad, missing/padRunes))
+		builder.WriteString(string([]rune(pad)[:missing%padRunes]))
+		if name == "padStart" {
+			builder.WriteString(s)
+		}
+		return builder.String()
+	case "repeat":
+		count := toIndex(valueArg(name, args, 0))
+		if count < 0 {
+			panic("repeat() count must not be negative")
+		}
+		return strings.Repeat(s, count)
+	default:
+		panic("Unknown string method: " + name)
+	}
+}
+
+// stringIndex returns the rune at index i of s as a one character string.
+func stringIndex(s string, index int) string {
+	runes := []rune(s)
+	if index < 0 || index >= len(runes) {
+		panic("String index out of bounds")
+	}
+	return string(runes[index])
+}
+
+func stringArg(method string, args []interface{}, index int) string {
+	s, ok := valueArg(method, args, index).(string)
+	if !ok {
+		panic(fmt.Sprintf("%s() argument %d must be a string", method, index+1))
+	}
+	return s
+}
+
+func clampIndex(index int, length int) int {
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
+	}
+	return index
+}
+
+// paddingLength returns the length in bytes of missing runes of padding
+// made of pad, which must not be empty.
+func paddingLength(pad string, missing int) int64 {
+	padRunes := utf8.RuneCountInString(pad)
+	whole := object.MulSize(int64(len(pad)), int64(missing/padRunes))
+	return object.AddSize(whole, int64(len(string([]rune(pad)[:missing%padRunes]))))
+}
@@ -45,6 +45,14 @@ func Lex(input string) []token.Token {
 				typ = token.TokenFn
 			case "return":
 				typ = token.TokenReturn
+			case "in":
+				typ = token.TokenIn
+			case "match":
+				typ = token.TokenMatch
+			case "import":
+				typ = token.TokenImport
+			case "export":
+				typ = token.TokenExport
 			case "true":
 				typ = token.TokenTrue
 			case "false":
@@ -123,6 +131,9 @@ func Lex(input string) []token.Token {
 			if i+1 < len(input) && input[i+1] == '=' {
 				tokens = append(tokens, token.Token{Type: token.TokenEqual, Literal: "=="})
 				i += 2
+			} else if i+1 < len(input) && input[i+1] == '>' {
+				tokens = append(tokens, token.Token{Type: token.TokenArrow, Literal: "=>"})
+				i += 2
 			} else {
 				tokens = append(tokens, token.Token{Type: token.TokenAssign, Literal: "="})
 				i++
@@ -178,7 +189,7 @@ func Lex(input string) []token.Token {
 				i++
 			}
 			if i >= len(input) || input[i] != '"' {
-				panic(errorexception.UnTerminatedStringException{
+				panic(&errorexception.UnTerminatedStringException{
 					Message: "Unterminated string literal",
 				})
 			}
@@ -239,14 +250,31 @@ func Lex(input string) []token.Token {
 				continue
 			}
 		case '?':
-			i++
-			tokens = append(tokens, token.Token{Type: token.TokenQuestion, Literal: "?"})
+			if i+1 < len(input) && input[i+1] == '?' {
+				tokens = append(tokens, token.Token{Type: token.TokenNullish, Literal: "??"})
+				i += 2
+			} else if i+1 < len(input) && input[i+1] == '.' && !(i+2 < len(input) && IsDigit(rune(input[i+2]))) {
+				// `a?.b`, but not a ternary like `a ?.5 : 1`
+				tokens = append(tokens, token.Token{Type: token.TokenOptionalDot, Literal: "?."})
+				i += 2
+			} else {
+				i++
+				tokens = append(tokens, token.Token{Type: token.TokenQuestion, Literal: "?"})
+			}
 		case ':':
 			tokens = append(tokens, token.Token{Type: token.TokenColon, Literal: ":"})
 			i++
 		case '.':
-			tokens = append(tokens, token.Token{Type: token.TokenDot, Literal: "."})
-			i++
+			if i+2 < len(input) && input[i+1] == '.' && input[i+2] == '.' {
+				tokens = append(tokens, token.Token{Type: token.TokenSpread, Literal: "..."})
+				i += 3
+			} else if i+1 < len(input) && input[i+1] == '.' {
+				tokens = append(tokens, token.Token{Type: token.TokenRange, Literal: ".."})
+				i += 2
+			} else {
+				tokens = append(tokens, token.Token{Type: token.TokenDot, Literal: "."})
+				i++
+			}
 		case '[':
 			tokens = append(tokens, token.Token{Type: token.TokenLBracket, Literal: "["})
 			i++
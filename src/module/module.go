@@ -0,0 +1,199 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	lexer "theparadance.com/quan-lang/src/lexer"
+	parser "theparadance.com/quan-lang/src/paraser"
+	"theparadance.com/quan-lang/src/resolver"
+)
+
+// ModuleLoader finds and reads the source of imported modules.
+type ModuleLoader interface {
+	// Resolve returns the canonical name of the module that `importer`
+	// refers to with `path`. The name is used as the cache key.
+	Resolve(importer string, path string) (string, error)
+	// Load returns the source code of a resolved module.
+	Load(name string) (string, error)
+}
+
+// FileSystemLoader loads modules from disk. Relative paths are resolved
+// against the directory of the importing file. Modules outside of Root
+// cannot be imported; a loader without a Root refuses every import.
+type FileSystemLoader struct {
+	Root string
+}
+
+func NewFileSystemLoader(root string) *FileSystemLoader {
+	return &FileSystemLoader{Root: root}
+}
+
+func (loader *FileSystemLoader) Resolve(importer string, importPath string) (string, error) {
+	if loader.Root == "" {
+		return "", errors.New("the module loader has no root directory")
+	}
+	name := importPath
+	if !filepath.IsAbs(name) {
+		base := loader.Root
+		if importer != "" {
+			base = filepath.Dir(importer)
+		}
+		name = filepath.Join(base, name)
+	}
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := filepath.Abs(loader.Root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("module %q is outside of %q", importPath, loader.Root)
+	}
+	return name, nil
+}
+
+func (loader *FileSystemLoader) Load(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// MapLoader serves modules from memory, keyed by slash separated names such
+// as "lib/math.qlang". It is meant for WASM and server hosts.
+type MapLoader struct {
+	Modules map[string]string
+}
+
+func NewMapLoader(modules map[string]string) *MapLoader {
+	return &MapLoader{Modules: modules}
+}
+
+func (loader *MapLoader) Resolve(importer string, importPath string) (string, error) {
+	name := importPath
+	if !path.IsAbs(name) {
+		name = path.Join(path.Dir(importer), name)
+	}
+	return strings.TrimPrefix(path.Clean(name), "/"), nil
+}
+
+func (loader *MapLoader) Load(name string) (string, error) {
+	source, ok := loader.Modules[name]
+	if !ok {
+		return "", fmt.Errorf("module %q not found", name)
+	}
+	return source, nil
+}
+
+// Module is an executed module and the values it exports.
+type Module struct {
+	Name    string
+	Exports map[string]interface{}
+}
+
+// Cache keeps parsed modules across executions, so a module imported by
+// many of them is parsed once. A module is parsed again when its loader
+// returns a different source for it. A Cache is safe for concurrent use.
+type Cache struct {
+	mutex   sync.Mutex
+	modules map[string]parsedModule
+}
+
+type parsedModule struct {
+	source   string
+	resolved resolver.Result
+}
+
+func NewCache() *Cache {
+	return &Cache{modules: make(map[string]parsedModule)}
+}
+
+// parse parses and resolves the source of the module name. A nil cache
+// parses it every time.
+func (cache *Cache) parse(name string, source string) resolver.Result {
+	if cache == nil {
+		return parse(source)
+	}
+	cache.mutex.Lock()
+	parsed, ok := cache.modules[name]
+	cache.mutex.Unlock()
+	if ok && parsed.source == source {
+		return parsed.resolved
+	}
+
+	resolved := parse(source)
+	cache.mutex.Lock()
+	cache.modules[name] = parsedModule{source: source, resolved: resolved}
+	cache.mutex.Unlock()
+	return resolved
+}
+
+func parse(source string) resolver.Result {
+	p := parser.Parser{Tokens: lexer.Lex(source)}
+	return resolver.Resolve(p.Parse())
+}
+
+// Registry runs each module once per registry, that is once per execution,
+// and detects import cycles. Parsed modules come from Cache when it is set.
+type Registry struct {
+	Loader  ModuleLoader
+	Cache   *Cache
+	modules map[string]*Module
+	loading []string // modules currently being executed, outermost first
+}
+
+func NewRegistry(loader ModuleLoader, cache *Cache) *Registry {
+	return &Registry{
+		Loader:  loader,
+		Cache:   cache,
+		modules: make(map[string]*Module),
+	}
+}
+
+// Import resolves `importPath` relative to `importer` and returns the
+// module, calling run to execute it the first time it is imported.
+func (registry *Registry) Import(importer string, importPath string, run func(name string, resolved resolver.Result) map[string]interface{}) (*Module, error) {
+	name, err := registry.Loader.Resolve(importer, importPath)
+	if err != nil {
+		return nil, err
+	}
+	if mod, ok := registry.modules[name]; ok {
+		return mod, nil
+	}
+
+	for i, loading := range registry.loading {
+		if loading == name {
+			cycle := append(append([]string{}, registry.loading[i:]...), name)
+			return nil, fmt.Errorf("import cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	source, err := registry.Loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	resolved := registry.Cache.parse(name, source)
+
+	registry.loading = append(registry.loading, name)
+	defer func() {
+		registry.loading = registry.loading[:len(registry.loading)-1]
+	}()
+
+	mod := &Module{
+		Name:    name,
+		Exports: run(name, resolved),
+	}
+	registry.modules[name] = mod
+	return mod, nil
+}
@@ -0,0 +1,33 @@
+package object
+
+import "bytes"
+
+// Array is the runtime representation of a script array. Arrays are shared
+// like objects: every variable, property and element holding an array
+// refers to the same Array, so a change made through one of them, e.g.
+// `a.push(1)` or `a[0] = 1`, is seen through all of them.
+type Array struct {
+	Items []interface{}
+}
+
+// NewArray returns an array holding items, which it takes ownership of.
+func NewArray(items []interface{}) *Array {
+	return &Array{Items: items}
+}
+
+func (a *Array) Len() int {
+	return len(a.Items)
+}
+
+func (a *Array) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (&JSONWriter{}).Write(&buf, a); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// String formats the array like fmt's %v formats a slice, e.g. [1 2 3].
+func (a *Array) String() string {
+	return Sprint(a)
+}
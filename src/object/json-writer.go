@@ -0,0 +1,183 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrCyclicValue is returned when an array or object contains itself.
+var ErrCyclicValue = errors.New("cannot serialise cyclic value")
+
+// JSONWriter serialises runtime values as compact JSON, keeping the order
+// of object keys. Arrays and objects containing themselves are reported with
+// ErrCyclicValue; the same value reached twice without a cycle is written
+// twice. Object.MarshalJSON, Object.String and toJson all write through it.
+type JSONWriter struct {
+	// Other writes the values JSONWriter does not know itself, such as host
+	// values and functions. Without it they are written with json.Marshal.
+	Other func(buf *bytes.Buffer, val interface{}) error
+	// Reserve, when set, is called with the number of bytes appended to the
+	// buffer after every value, so that a caller can stop an output too
+	// large for its memory quota before it has been built. A JSONWriter with
+	// Reserve writes to one buffer only.
+	Reserve func(size int64)
+
+	active   map[interface{}]bool // the *Array and *Object values being written
+	reserved int                  // the length of the buffer passed to Reserve
+}
+
+// Write appends val to buf.
+func (writer *JSONWriter) Write(buf *bytes.Buffer, val interface{}) error {
+	err := writer.write(buf, val)
+	writer.reserve(buf)
+	return err
+}
+
+func (writer *JSONWriter) reserve(buf *bytes.Buffer) {
+	if writer.Reserve != nil && buf.Len() > writer.reserved {
+		size := buf.Len() - writer.reserved
+		writer.reserved = buf.Len()
+		writer.Reserve(int64(size))
+	}
+}
+
+func (writer *JSONWriter) write(buf *bytes.Buffer, val interface{}) error {
+	switch v := val.(type) {
+	case nil, *Null:
+		buf.WriteString("null")
+	case bool, string, int, int64:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("cannot serialise %v", v)
+		}
+		data, _ := json.Marshal(v)
+		buf.Write(data)
+	case *Array:
+		if err := writer.enter(v); err != nil {
+			return err
+		}
+		defer delete(writer.active, v)
+		buf.WriteByte('[')
+		for i, item := range v.Items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writer.Write(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case *Object:
+		if err := writer.enter(v); err != nil {
+			return err
+		}
+		defer delete(writer.active, v)
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			keyData, _ := json.Marshal(key)
+			buf.Write(keyData)
+			buf.WriteByte(':')
+			if err := writer.Write(buf, v.properties[key]); err != nil {
+				return fmt.Errorf("%w (at key %q)", err, key)
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		if writer.Other != nil {
+			return writer.Other(buf, val)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+func (writer *JSONWriter) enter(val interface{}) error {
+	if writer.active == nil {
+		writer.active = make(map[interface{}]bool)
+	}
+	if writer.active[val] {
+		return ErrCyclicValue
+	}
+	writer.active[val] = true
+	return nil
+}
+
+// Sprint formats val like fmt's %v, except that an array containing itself
+// is shown as [...] instead of recursing forever.
+func Sprint(val interface{}) string {
+	var buf bytes.Buffer
+	(&Printer{}).Print(&buf, val)
+	return buf.String()
+}
+
+// SprintItems formats the elements of arr with Sprint.
+func SprintItems(arr *Array) []string {
+	printer := &Printer{active: map[*Array]bool{arr: true}}
+	items := make([]string, len(arr.Items))
+	for i, item := range arr.Items {
+		var buf bytes.Buffer
+		printer.Print(&buf, item)
+		items[i] = buf.String()
+	}
+	return items
+}
+
+// Printer formats values like Sprint. Reserve, when set, is called like
+// JSONWriter.Reserve as the text grows. A Printer writes to one buffer only.
+type Printer struct {
+	Reserve func(size int64)
+
+	json   JSONWriter
+	active map[*Array]bool
+}
+
+// Print appends val formatted like Sprint to buf.
+func (printer *Printer) Print(buf *bytes.Buffer, val interface{}) {
+	printer.json.Reserve = printer.Reserve
+	switch v := val.(type) {
+	case *Array:
+		if printer.active == nil {
+			printer.active = make(map[*Array]bool)
+		}
+		if printer.active[v] {
+			buf.WriteString("[...]")
+			break
+		}
+		printer.active[v] = true
+		defer delete(printer.active, v)
+		buf.WriteByte('[')
+		for i, item := range v.Items {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			printer.Print(buf, item)
+		}
+		buf.WriteByte(']')
+	case *Object:
+		start := buf.Len()
+		if v == nil || printer.json.Write(buf, v) != nil {
+			// like Object.String
+			buf.Truncate(start)
+			printer.json.reserved = min(printer.json.reserved, start)
+			fmt.Fprintf(buf, "%v", v)
+		}
+	default:
+		fmt.Fprintf(buf, "%v", v)
+	}
+	printer.json.reserve(buf)
+}
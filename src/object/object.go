@@ -1,17 +1,236 @@
 package object
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 type Null struct{}
 
+// NULL is the runtime null value.
+var NULL = &Null{}
+
+func (n *Null) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+func (n *Null) String() string {
+	return "null"
+}
+
 type Value interface{}
+
+// Properties is implemented by values whose properties scripts can read:
+// objects and Go structs bound to the environment.
+type Properties interface {
+	Keys() []string
+	GetProperty(name string) (Value, bool)
+}
+
+// Object is the runtime representation of a script object. Properties keep
+// the order in which they were first set, which is the order used when the
+// object is iterated, printed or serialised to JSON.
 type Object struct {
-	Properties map[string]Value
+	keys       []string
+	properties map[string]Value
+}
+
+func NewObject() *Object {
+	return &Object{
+		properties: make(map[string]Value),
+	}
 }
 
 func (o *Object) SetProperty(name string, val Value) {
-	o.Properties[name] = val
+	if _, ok := o.properties[name]; !ok {
+		o.keys = append(o.keys, name)
+	}
+	o.properties[name] = val
 }
 
 func (o *Object) GetProperty(name string) (Value, bool) {
-	val, ok := o.Properties[name]
+	val, ok := o.properties[name]
 	return val, ok
 }
+
+func (o *Object) HasProperty(name string) bool {
+	_, ok := o.properties[name]
+	return ok
+}
+
+// DeleteProperty removes a property and reports whether it existed.
+func (o *Object) DeleteProperty(name string) bool {
+	if _, ok := o.properties[name]; !ok {
+		return false
+	}
+	delete(o.properties, name)
+	for i, key := range o.keys {
+		if key == name {
+			o.keys = append(o.keys[:i:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Keys returns the property names in insertion order.
+func (o *Object) Keys() []string {
+	keys := make([]string, len(o.keys))
+	copy(keys, o.keys)
+	return keys
+}
+
+func (o *Object) Len() int {
+	return len(o.keys)
+}
+
+// ToMap converts the object, and any objects nested in it, to plain Go maps.
+func (o *Object) ToMap() map[string]interface{} {
+	result := make(map[string]interface{}, len(o.keys))
+	for _, key := range o.keys {
+		result[key] = ToGo(o.properties[key])
+	}
+	return result
+}
+
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (&JSONWriter{}).Write(&buf, o); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (o *Object) String() string {
+	data, err := o.MarshalJSON()
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// FromGo converts host values into runtime values: Go maps become Objects
+// (with keys in sorted order, since Go maps are unordered), nil becomes NULL
+// and slices are copied into Arrays and converted element by element, so
+// the host's values are never modified. Other values are returned unchanged.
+func FromGo(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil:
+		return NULL
+	case map[string]interface{}:
+		obj := NewObject()
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			obj.SetProperty(key, FromGo(v[key]))
+		}
+		return obj
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = FromGo(item)
+		}
+		return NewArray(result)
+	default:
+		return val
+	}
+}
+
+// ToGo converts runtime values back into plain Go maps and slices, with
+// NULL as nil.
+func ToGo(val interface{}) interface{} {
+	switch v := val.(type) {
+	case *Null:
+		return nil
+	case *Object:
+		return v.ToMap()
+	case *Array:
+		result := make([]interface{}, len(v.Items))
+		for i, item := range v.Items {
+			result[i] = ToGo(item)
+		}
+		return result
+	default:
+		return val
+	}
+}
+
+// ParseJSON decodes JSON into runtime values, keeping object keys in the
+// order they appear in the document. Numbers without a fraction or exponent
+// become int, other numbers float64, and null becomes NULL.
+func ParseJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	val, err := parseJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, errors.New("unexpected data after top-level JSON value")
+	}
+	return val, nil
+}
+
+func parseJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := NewObject()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := parseJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.SetProperty(keyTok.(string), val)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return obj, nil
+		case '[':
+			arr := NewArray([]interface{}{})
+			for dec.More() {
+				val, err := parseJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr.Items = append(arr.Items, val)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return arr, nil
+		}
+	case json.Number:
+		return jsonNumber(t), nil
+	case nil:
+		return NULL, nil
+	}
+	return tok, nil
+}
+
+func jsonNumber(n json.Number) interface{} {
+	if !strings.ContainsAny(n.String(), ".eE") {
+		if i, err := strconv.Atoi(n.String()); err == nil {
+			return i
+		}
+	}
+	f, _ := n.Float64()
+	return f
+}
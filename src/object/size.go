@@ -0,0 +1,98 @@
+package object
+
+import "math"
+
+// Estimated sizes in bytes, close to what the Go runtime allocates on a
+// 64-bit platform.
+const (
+	stringHeaderSize = 16
+	sliceHeaderSize  = 24
+	objectSize       = 64 // the Object itself and its empty map
+	propertySize     = 48 // map entry, key header and the key in the order list
+)
+
+// ElementSize is the estimated size of one array element, not counting the
+// value it holds.
+const ElementSize = 16
+
+// SizeOf estimates the memory held by a runtime value itself: the bytes of a
+// string, the elements of an array or the properties of an object. Values
+// nested in an array or object are not included. Numbers, booleans and null
+// count as zero.
+func SizeOf(val interface{}) int64 {
+	switch v := val.(type) {
+	case string:
+		return stringHeaderSize + int64(len(v))
+	case *Array:
+		return sliceHeaderSize + ElementSize*int64(len(v.Items))
+	case *Object:
+		size := int64(objectSize)
+		for _, key := range v.keys {
+			size += PropertySize(key)
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+// DeepSizeOf is SizeOf including the values nested in arrays and objects.
+// Arrays and objects reachable more than once are counted once.
+func DeepSizeOf(val interface{}) int64 {
+	return deepSizeOf(val, make(map[interface{}]bool))
+}
+
+func deepSizeOf(val interface{}, seen map[interface{}]bool) int64 {
+	switch val.(type) {
+	case *Array, *Object:
+		if seen[val] {
+			return 0
+		}
+		seen[val] = true
+	}
+	size := SizeOf(val)
+	switch v := val.(type) {
+	case *Array:
+		for _, item := range v.Items {
+			size += deepSizeOf(item, seen)
+		}
+	case *Object:
+		for _, key := range v.keys {
+			size += deepSizeOf(v.properties[key], seen)
+		}
+	}
+	return size
+}
+
+// StringSize is SizeOf for a string of length bytes.
+func StringSize(length int64) int64 {
+	return AddSize(stringHeaderSize, length)
+}
+
+// ArraySize is SizeOf for an array of length elements.
+func ArraySize(length int64) int64 {
+	return AddSize(sliceHeaderSize, MulSize(ElementSize, length))
+}
+
+// AddSize adds sizes, saturating instead of overflowing so that a huge
+// request still exceeds any quota.
+func AddSize(a, b int64) int64 {
+	if b > 0 && a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// MulSize multiplies non-negative sizes, saturating like AddSize.
+func MulSize(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+// PropertySize estimates the memory a property named key adds to an object,
+// without its value.
+func PropertySize(key string) int64 {
+	return propertySize + int64(len(key))
+}
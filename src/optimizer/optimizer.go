@@ -0,0 +1,465 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"theparadance.com/quan-lang/src/expression"
+	interpreter "theparadance.com/quan-lang/src/intepreter"
+	"theparadance.com/quan-lang/src/object"
+	"theparadance.com/quan-lang/src/token"
+)
+
+// maxInlineSize is the largest function body, counted in AST nodes, that
+// is inlined at its call sites.
+const maxInlineSize = 24
+
+// Optimize rewrites a parsed program into an equivalent, cheaper one:
+//
+//   - binary operators and template strings whose operands are constants
+//     are computed once, e.g. `60 * 60 * 24` becomes `86400`;
+//   - statements after a `return` and branches of an `if` or ternary whose
+//     condition is constant are removed;
+//   - calls to small functions whose body is a single `return` of an
+//     expression without calls or assignments are replaced by that
+//     expression, e.g. `fn double(x) { return x * 2; }` turns `double(n)`
+//     into `n * 2`.
+//
+// It runs on the parser's output, before the resolver. Expressions that
+// would fail at run time (division by zero, mismatched types) are left in
+// place so they still report their error when reached.
+func Optimize(ast []expression.Expr) []expression.Expr {
+	o := &optimizer{defs: make(map[string]int)}
+	ast = o.block(ast, programBlock)
+
+	// a second pass inlines, now that every function definition is known
+	o.inline = make(map[string]expression.FuncDef)
+	return o.block(ast, programBlock)
+}
+
+type optimizer struct {
+	defs   map[string]int                // number of definitions of each function name
+	inline map[string]expression.FuncDef // inlinable functions defined so far, nil in the first pass
+}
+
+type blockKind int
+
+const (
+	// programBlock is the top level; statements after a `return` there
+	// still run.
+	programBlock blockKind = iota
+	// bodyBlock is a function body or an `if` branch.
+	bodyBlock
+	// armBlock is a `match` arm body, whose value is its last statement.
+	armBlock
+)
+
+// block optimises a statement list. Constant `if` statements are replaced by
+// the statements of the branch taken, which is safe because `if` has no
+// scope of its own, except in arm bodies where that would change the arm's
+// value.
+func (o *optimizer) block(stmts []expression.Expr, kind blockKind) []expression.Expr {
+	if stmts == nil {
+		return nil
+	}
+	result := make([]expression.Expr, 0, len(stmts))
+	for _, stmt := range stmts {
+		stmt = o.expr(stmt)
+		if def, ok := stmt.(expression.FuncDef); ok && kind == programBlock && o.inline != nil {
+			// only calls after the definition see it
+			if o.defs[def.Name] == 1 && inlinable(def) {
+				o.inline[def.Name] = def
+			}
+		}
+		if ifExpr, ok := stmt.(expression.IfExpr); ok && kind != armBlock {
+			if cond, ok := constant(ifExpr.Condition); ok {
+				branch := ifExpr.Else
+				if interpreter.IsTruthy(cond) {
+					branch = ifExpr.Then
+				}
+				result = append(result, branch...)
+				if kind == bodyBlock && endsWithReturn(branch) {
+					break
+				}
+				continue
+			}
+		}
+		result = append(result, stmt)
+		if _, ok := stmt.(expression.ReturnExpr); ok && kind != programBlock {
+			// the rest of the block never runs
+			break
+		}
+	}
+	return result
+}
+
+func endsWithReturn(stmts []expression.Expr) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	_, ok := stmts[len(stmts)-1].(expression.ReturnExpr)
+	return ok
+}
+
+func (o *optimizer) optional(expr expression.Expr) expression.Expr {
+	if expr == nil {
+		return nil
+	}
+	return o.expr(expr)
+}
+
+func (o *optimizer) exprs(exprs []expression.Expr) []expression.Expr {
+	if exprs == nil {
+		return nil
+	}
+	result := make([]expression.Expr, len(exprs))
+	for i, expr := range exprs {
+		result[i] = o.expr(expr)
+	}
+	return result
+}
+
+func (o *optimizer) expr(expr expression.Expr) expression.Expr {
+	switch e := expr.(type) {
+	case expression.AssignExpr:
+		return expression.AssignExpr{Target: o.target(e.Target), Value: o.expr(e.Value)}
+	case expression.TemplateStringExpr:
+		return foldTemplate(o.exprs(e.Value))
+	case expression.BinaryExpr:
+		return foldBinary(expression.BinaryExpr{Left: o.expr(e.Left), Operator: e.Operator, Right: o.expr(e.Right)})
+	case expression.IfExpr:
+		return expression.IfExpr{Condition: o.expr(e.Condition), Then: o.block(e.Then, bodyBlock), Else: o.block(e.Else, bodyBlock)}
+	case expression.TernaryExpr:
+		condition := o.expr(e.Condition)
+		if cond, ok := constant(condition); ok {
+			if interpreter.IsTruthy(cond) {
+				return o.expr(e.TrueValue)
+			}
+			return o.expr(e.FalseValue)
+		}
+		return expression.TernaryExpr{Condition: condition, TrueValue: o.expr(e.TrueValue), FalseValue: o.expr(e.FalseValue)}
+	case expression.MatchExpr:
+		subject := o.expr(e.Subject)
+		arms := make([]expression.MatchArm, len(e.Arms))
+		for i, arm := range e.Arms {
+			arms[i] = expression.MatchArm{Pattern: arm.Pattern, Guard: o.optional(arm.Guard), Body: o.block(arm.Body, armBlock)}
+		}
+		return expression.MatchExpr{Subject: subject, Arms: arms}
+	case expression.FuncDef:
+		if e.Name != "" && o.inline == nil {
+			o.defs[e.Name]++
+		}
+		return expression.FuncDef{Name: e.Name, Params: e.Params, Body: o.block(e.Body, bodyBlock), Scope: e.Scope}
+	case expression.FuncCall:
+		args := o.exprs(e.Args)
+		if def, ok := o.inline[e.Name]; ok {
+			if inlined, ok := inlineCall(def, args); ok {
+				return o.expr(inlined)
+			}
+		}
+		return expression.FuncCall{Name: e.Name, Args: args}
+	case expression.CallExpr:
+		return expression.CallExpr{Callee: o.expr(e.Callee), Args: o.exprs(e.Args), Optional: e.Optional}
+	case expression.ReturnExpr:
+		return expression.ReturnExpr{Value: o.optional(e.Value)}
+	case expression.ObjectExpr:
+		pairs := make([]expression.ObjectPair, len(e.Pairs))
+		for i, pair := range e.Pairs {
+			pairs[i] = expression.ObjectPair{Key: pair.Key, Value: o.expr(pair.Value)}
+		}
+		return expression.ObjectExpr{Pairs: pairs}
+	case expression.MemberExpr:
+		return expression.MemberExpr{Object: o.expr(e.Object), Property: e.Property, Optional: e.Optional}
+	case expression.IndexExpr:
+		return expression.IndexExpr{Array: o.expr(e.Array), Index: o.expr(e.Index), Optional: e.Optional}
+	case expression.ArrayExpr:
+		return expression.ArrayExpr{Elements: o.exprs(e.Elements)}
+	case expression.SpreadExpr:
+		return expression.SpreadExpr{Value: o.expr(e.Value)}
+	case expression.ExportExpr:
+		return expression.ExportExpr{Decl: o.optional(e.Decl), Names: e.Names}
+	default:
+		// literals, variables and imports
+		return expr
+	}
+}
+
+// target optimises the expressions inside an assignment target, leaving the
+// names it assigns alone.
+func (o *optimizer) target(target expression.Expr) expression.Expr {
+	switch t := target.(type) {
+	case expression.ObjectPattern:
+		return expression.ObjectPattern{Properties: o.patternElements(t.Properties), Rest: t.Rest}
+	case expression.ArrayPattern:
+		return expression.ArrayPattern{Elements: o.patternElements(t.Elements), Rest: t.Rest}
+	case expression.MemberExpr, expression.IndexExpr:
+		return o.expr(target)
+	default:
+		return target
+	}
+}
+
+func (o *optimizer) patternElements(elements []expression.PatternElement) []expression.PatternElement {
+	result := make([]expression.PatternElement, len(elements))
+	for i, element := range elements {
+		result[i] = expression.PatternElement{
+			Key:     element.Key,
+			Target:  o.target(element.Target),
+			Default: o.optional(element.Default),
+		}
+	}
+	return result
+}
+
+// constant returns the value of a literal or of an operator applied to
+// constants. Operators that would panic are not constant.
+func constant(expr expression.Expr) (val interface{}, ok bool) {
+	switch e := expr.(type) {
+	case expression.NumberExpr:
+		return e.Value, true
+	case expression.StringExpr:
+		return e.Value, true
+	case expression.BooleanExpr:
+		return e.Value, true
+	case expression.NullExpr:
+		return interpreter.Null, true
+	case expression.BinaryExpr:
+		left, ok := constant(e.Left)
+		if !ok {
+			return nil, false
+		}
+		if e.Operator.Type == token.TokenNullish {
+			if interpreter.IsNull(left) {
+				return constant(e.Right)
+			}
+			return left, true
+		}
+		right, ok := constant(e.Right)
+		if !ok {
+			return nil, false
+		}
+		defer func() {
+			if recover() != nil {
+				val, ok = nil, false
+			}
+		}()
+		return interpreter.BinaryOp(e.Operator, left, right), true
+	default:
+		return nil, false
+	}
+}
+
+// literal returns the AST node for a constant value. Comparisons produce
+// integers, which have no literal, and stay unfolded.
+func literal(val interface{}) (expression.Expr, bool) {
+	switch v := val.(type) {
+	case float64:
+		return expression.NumberExpr{Value: v}, true
+	case string:
+		return expression.StringExpr{Value: v}, true
+	case bool:
+		return expression.BooleanExpr{Value: v}, true
+	case *object.Null:
+		return expression.NullExpr{}, true
+	default:
+		return nil, false
+	}
+}
+
+func foldBinary(e expression.BinaryExpr) expression.Expr {
+	if e.Operator.Type == token.TokenNullish {
+		// `a ?? b` with a constant a is one of its operands
+		if left, ok := constant(e.Left); ok {
+			if interpreter.IsNull(left) {
+				return e.Right
+			}
+			return e.Left
+		}
+		return e
+	}
+	if val, ok := constant(e); ok {
+		if folded, ok := literal(val); ok {
+			return folded
+		}
+	}
+	return e
+}
+
+// foldTemplate joins constant parts of a template string. A template made
+// only of constants becomes a string literal.
+func foldTemplate(parts []expression.Expr) expression.Expr {
+	var result []expression.Expr
+	var text strings.Builder
+	pending := false
+	flush := func() {
+		if pending {
+			result = append(result, expression.StringExpr{Value: text.String()})
+			text.Reset()
+			pending = false
+		}
+	}
+	for _, part := range parts {
+		if val, ok := constant(part); ok {
+			// the interpreter formats interpolated values with fmt.Sprint
+			text.WriteString(fmt.Sprint(val))
+			pending = true
+			continue
+		}
+		flush()
+		result = append(result, part)
+	}
+	flush()
+
+	if len(result) == 0 {
+		return expression.StringExpr{Value: ""}
+	}
+	if len(result) == 1 {
+		if s, ok := result[0].(expression.StringExpr); ok {
+			return s
+		}
+	}
+	return expression.TemplateStringExpr{Value: result}
+}
+
+// inlinable reports whether def is `fn name(params) { return expr; }` where
+// expr is small and contains no calls, assignments or functions. Without
+// calls the function cannot be recursive, and because scoping is dynamic
+// the free names of expr mean the same at the call site.
+func inlinable(def expression.FuncDef) bool {
+	if len(def.Body) != 1 {
+		return false
+	}
+	ret, ok := def.Body[0].(expression.ReturnExpr)
+	if !ok || ret.Value == nil {
+		return false
+	}
+	size := 0
+	return simple(ret.Value, &size) && size <= maxInlineSize
+}
+
+// simple reports whether expr only reads values, counting its nodes in size.
+func simple(expr expression.Expr, size *int) bool {
+	*size++
+	switch e := expr.(type) {
+	case expression.NumberExpr, expression.StringExpr, expression.BooleanExpr, expression.NullExpr, expression.VarExpr:
+		return true
+	case expression.BinaryExpr:
+		return simple(e.Left, size) && simple(e.Right, size)
+	case expression.TernaryExpr:
+		return simple(e.Condition, size) && simple(e.TrueValue, size) && simple(e.FalseValue, size)
+	case expression.MemberExpr:
+		return simple(e.Object, size)
+	case expression.IndexExpr:
+		return simple(e.Array, size) && simple(e.Index, size)
+	case expression.SpreadExpr:
+		return simple(e.Value, size)
+	case expression.TemplateStringExpr:
+		return allSimple(e.Value, size)
+	case expression.ArrayExpr:
+		return allSimple(e.Elements, size)
+	case expression.ObjectExpr:
+		for _, pair := range e.Pairs {
+			if !simple(pair.Value, size) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func allSimple(exprs []expression.Expr, size *int) bool {
+	for _, expr := range exprs {
+		if !simple(expr, size) {
+			return false
+		}
+	}
+	return true
+}
+
+// inlineCall substitutes args for the parameters of def. Arguments are
+// evaluated before the body runs, so only literals and variables, which
+// read the same value anywhere in the body, are substituted. A variable
+// argument must also be used, otherwise an undefined name would no longer
+// be reported.
+func inlineCall(def expression.FuncDef, args []expression.Expr) (expression.Expr, bool) {
+	if len(args) != len(def.Params) {
+		// keep the arity error
+		return nil, false
+	}
+	body := def.Body[0].(expression.ReturnExpr).Value
+	params := make(map[string]expression.Expr, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case expression.NumberExpr, expression.StringExpr, expression.BooleanExpr, expression.NullExpr:
+		case expression.VarExpr:
+			if !uses(body, def.Params[i]) {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		params[def.Params[i]] = arg
+	}
+	return substitute(body, params), true
+}
+
+func uses(expr expression.Expr, name string) bool {
+	found := false
+	substitute(expr, nil, func(v expression.VarExpr) {
+		if v.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// substitute copies a simple expression, replacing the variables in params.
+// visit, if given, is called for every variable.
+func substitute(expr expression.Expr, params map[string]expression.Expr, visit ...func(expression.VarExpr)) expression.Expr {
+	sub := func(e expression.Expr) expression.Expr { return substitute(e, params, visit...) }
+	subAll := func(exprs []expression.Expr) []expression.Expr {
+		if exprs == nil {
+			return nil
+		}
+		result := make([]expression.Expr, len(exprs))
+		for i, e := range exprs {
+			result[i] = sub(e)
+		}
+		return result
+	}
+
+	switch e := expr.(type) {
+	case expression.VarExpr:
+		for _, v := range visit {
+			v(e)
+		}
+		if arg, ok := params[e.Name]; ok {
+			return arg
+		}
+		return e
+	case expression.BinaryExpr:
+		return expression.BinaryExpr{Left: sub(e.Left), Operator: e.Operator, Right: sub(e.Right)}
+	case expression.TernaryExpr:
+		return expression.TernaryExpr{Condition: sub(e.Condition), TrueValue: sub(e.TrueValue), FalseValue: sub(e.FalseValue)}
+	case expression.MemberExpr:
+		return expression.MemberExpr{Object: sub(e.Object), Property: e.Property, Optional: e.Optional}
+	case expression.IndexExpr:
+		return expression.IndexExpr{Array: sub(e.Array), Index: sub(e.Index), Optional: e.Optional}
+	case expression.SpreadExpr:
+		return expression.SpreadExpr{Value: sub(e.Value)}
+	case expression.TemplateStringExpr:
+		return expression.TemplateStringExpr{Value: subAll(e.Value)}
+	case expression.ArrayExpr:
+		return expression.ArrayExpr{Elements: subAll(e.Elements)}
+	case expression.ObjectExpr:
+		pairs := make([]expression.ObjectPair, len(e.Pairs))
+		for i, pair := range e.Pairs {
+			pairs[i] = expression.ObjectPair{Key: pair.Key, Value: sub(pair.Value)}
+		}
+		return expression.ObjectExpr{Pairs: pairs}
+	default:
+		return expr
+	}
+}
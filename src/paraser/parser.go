@@ -13,26 +13,46 @@ import (
 var precedences = map[token.TokenType]int{
 	token.TokenQuestion: 0,
 
-	token.TokenEqual: 1,
-	token.TokenNE:    1,
-	token.TokenLT:    1,
-	token.TokenLE:    1,
-	token.TokenGT:    1,
-	token.TokenGE:    1,
+	token.TokenNullish: 1,
 
-	token.TokenPlus:  2,
-	token.TokenMinus: 2,
+	token.TokenEqual: 2,
+	token.TokenNE:    2,
+	token.TokenLT:    2,
+	token.TokenLE:    2,
+	token.TokenGT:    2,
+	token.TokenGE:    2,
+	token.TokenIn:    2,
 
-	token.TokenStar:  3,
-	token.TokenSlash: 3,
-	token.TokenMod:   3,
+	token.TokenPlus:  3,
+	token.TokenMinus: 3,
 
-	token.TokenCaret: 4,
+	token.TokenStar:  4,
+	token.TokenSlash: 4,
+	token.TokenMod:   4,
+
+	token.TokenCaret: 5,
+}
+
+// keywords are the reserved words. They are still allowed as property names
+// and object keys, e.g. `o.match` and `{ in: 1 }`, so data keeps its keys.
+var keywords = map[token.TokenType]bool{
+	token.TokenIf:     true,
+	token.TokenElse:   true,
+	token.TokenFn:     true,
+	token.TokenReturn: true,
+	token.TokenIn:     true,
+	token.TokenMatch:  true,
+	token.TokenImport: true,
+	token.TokenExport: true,
+	token.TokenTrue:   true,
+	token.TokenFalse:  true,
+	token.TokenNull:   true,
 }
 
 type Parser struct {
 	Tokens []token.Token
 	pos    int
+	depth  int // blocks the parser is inside of; 0 at the top level
 }
 
 func NewParser(tokens []token.Token) *Parser {
@@ -57,6 +77,13 @@ func (p *Parser) peek() token.Token {
 	return p.Tokens[p.pos]
 }
 
+func (p *Parser) peekAt(offset int) token.Token {
+	if p.pos+offset >= len(p.Tokens) {
+		return token.Token{Type: token.TokenEOF}
+	}
+	return p.Tokens[p.pos+offset]
+}
+
 func (p *Parser) advance() token.Token {
 	tok := p.peek()
 	p.pos++
@@ -79,7 +106,7 @@ func (p *Parser) match(tt ...token.TokenType) bool {
 func (p *Parser) consume(t token.TokenType) token.Token {
 	tok := p.peek()
 	if tok.Type != t {
-		panic(errorexception.UnExpectedTokenError{
+		panic(&errorexception.UnExpectedTokenError{
 			Message: fmt.Sprintf("Expected token %s, got %s (%s)", t, tok.Type, tok.Literal),
 		})
 	}
@@ -94,6 +121,17 @@ func (p *Parser) parseStatement() expression.Expr {
 	if p.match(token.TokenIf) {
 		return p.parseIf()
 	}
+	if p.match(token.TokenImport) {
+		return p.parseImport()
+	}
+	if p.match(token.TokenExport) {
+		if p.depth > 0 {
+			panic(&errorexception.UnExpectedTokenError{
+				Message: "export is only allowed at the top level of a module",
+			})
+		}
+		return p.parseExport()
+	}
 	if p.match(token.TokenReturn) {
 		// Handle `return` with or without a value
 		if p.peek().Type == token.TokenSemicolon || p.peek().Type == token.TokenEOF || p.peek().Type == token.TokenRBrace {
@@ -118,40 +156,110 @@ func (p *Parser) parseStatement() expression.Expr {
 func (p *Parser) parseFunction() expression.Expr {
 	name := p.consume(token.TokenIdent).Literal
 	p.consume(token.TokenLParen)
-	var params []string
-	if !p.match(token.TokenRParen) {
-		params = append(params, p.consume(token.TokenIdent).Literal)
-		for p.match(token.TokenComma) {
-			params = append(params, p.consume(token.TokenIdent).Literal)
-		}
-		p.consume(token.TokenRParen)
-	}
+	params, prologue := p.parseParams()
 	p.consume(token.TokenLBrace)
 	body := p.parseBlock()
 	p.consume(token.TokenRBrace)
-	return expression.FuncDef{Name: name, Params: params, Body: body}
+	return expression.FuncDef{Name: name, Params: params, Body: append(prologue, body...)}
 }
 
 func (p *Parser) parseAnonFunction() expression.Expr {
 	p.consume(token.TokenLParen)
+	params, prologue := p.parseParams()
+	p.consume(token.TokenLBrace)
+	body := p.parseBlock()
+	p.consume(token.TokenRBrace)
+	return expression.FuncDef{
+		Params: params,
+		Body:   append(prologue, body...),
+	}
+}
+
+// parseParams parses a parameter list after the opening '(' up to and
+// including the closing ')'. A destructuring parameter such as `{ name, age }`
+// is given a generated name, and the returned prologue statements
+// destructure it at the start of the function body.
+func (p *Parser) parseParams() ([]string, []expression.Expr) {
 	var params []string
-	if !p.match(token.TokenRParen) {
-		params = append(params, p.consume(token.TokenIdent).Literal)
-		for p.match(token.TokenComma) {
+	var prologue []expression.Expr
+	if p.match(token.TokenRParen) {
+		return params, prologue
+	}
+	for {
+		switch p.peek().Type {
+		case token.TokenLBrace, token.TokenLBracket:
+			name := fmt.Sprintf("$param%d", len(params))
+			var literal expression.Expr
+			if p.peek().Type == token.TokenLBrace {
+				literal = p.parseObjectLiteral()
+			} else {
+				literal = p.parseArrayLiteral()
+			}
+			params = append(params, name)
+			prologue = append(prologue, expression.AssignExpr{
+				Target: toPattern(literal),
+				Value:  expression.VarExpr{Name: name},
+			})
+		default:
 			params = append(params, p.consume(token.TokenIdent).Literal)
 		}
-		p.consume(token.TokenRParen)
+		if !p.match(token.TokenComma) {
+			break
+		}
+	}
+	p.consume(token.TokenRParen)
+	return params, prologue
+}
+
+// isArrowFunction looks ahead from a '(' to its matching ')' and reports
+// whether the parenthesised list is followed by '=>'.
+func (p *Parser) isArrowFunction() bool {
+	depth := 0
+	for i := p.pos; i < len(p.Tokens); i++ {
+		switch p.Tokens[i].Type {
+		case token.TokenLParen:
+			depth++
+		case token.TokenRParen:
+			depth--
+			if depth == 0 {
+				return i+1 < len(p.Tokens) && p.Tokens[i+1].Type == token.TokenArrow
+			}
+		case token.TokenEOF:
+			return false
+		}
+	}
+	return false
+}
+
+// parseArrowFunction parses `x => expr`, `(a, b) => expr` and `(a) => { ... }`.
+// An expression body is wrapped in a ReturnExpr so the result is the same
+// runtime value as an anonymous `fn`.
+func (p *Parser) parseArrowFunction() expression.Expr {
+	var params []string
+	var prologue []expression.Expr
+	if p.match(token.TokenLParen) {
+		params, prologue = p.parseParams()
+	} else {
+		params = append(params, p.consume(token.TokenIdent).Literal)
+	}
+	p.consume(token.TokenArrow)
+
+	var body []expression.Expr
+	if p.match(token.TokenLBrace) {
+		body = p.parseBlock()
+		p.consume(token.TokenRBrace)
+	} else {
+		body = []expression.Expr{expression.ReturnExpr{Value: p.parseExpr()}}
 	}
-	p.consume(token.TokenLBrace)
-	body := p.parseBlock()
-	p.consume(token.TokenRBrace)
 	return expression.FuncDef{
 		Params: params,
-		Body:   body,
+		Body:   append(prologue, body...),
 	}
 }
 
 func (p *Parser) parseBlock() []expression.Expr {
+	p.depth++
+	defer func() { p.depth-- }()
 	var stmts []expression.Expr
 	for p.peek().Type != token.TokenRBrace && p.peek().Type != token.TokenEOF {
 		stmts = append(stmts, p.parseStatement())
@@ -168,13 +276,169 @@ func (p *Parser) parseIf() expression.Expr {
 	p.consume(token.TokenRBrace)
 	var elseBlock []expression.Expr
 	if p.match(token.TokenElse) {
-		p.consume(token.TokenLBrace)
-		elseBlock = p.parseBlock()
-		p.consume(token.TokenRBrace)
+		if p.match(token.TokenIf) {
+			// else if: the chained if is the only statement of the else block
+			elseBlock = []expression.Expr{p.parseIf()}
+		} else {
+			p.consume(token.TokenLBrace)
+			elseBlock = p.parseBlock()
+			p.consume(token.TokenRBrace)
+		}
 	}
 	return expression.IfExpr{Condition: cond, Then: thenBlock, Else: elseBlock}
 }
 
+// parseImport parses `import { a, b as c } from "path"` or `import "path"`.
+// `from` and `as` are only keywords in this position.
+func (p *Parser) parseImport() expression.Expr {
+	var names []expression.ImportName
+	if p.match(token.TokenLBrace) {
+		for p.peek().Type != token.TokenRBrace {
+			name := p.consume(token.TokenIdent).Literal
+			alias := name
+			if p.peek().Type == token.TokenIdent && p.peek().Literal == "as" {
+				p.advance()
+				alias = p.consume(token.TokenIdent).Literal
+			}
+			names = append(names, expression.ImportName{Name: name, Alias: alias})
+			if !p.match(token.TokenComma) {
+				break
+			}
+		}
+		p.consume(token.TokenRBrace)
+		p.consumeContextual("from")
+	}
+	path := p.consume(token.TokenString).Literal
+	p.match(token.TokenSemicolon) // optional semicolon
+	return expression.ImportExpr{Names: names, Path: path}
+}
+
+// parseExport parses `export fn f() {}`, `export x = value` or
+// `export { a, b }`.
+func (p *Parser) parseExport() expression.Expr {
+	if p.match(token.TokenFn) {
+		return expression.ExportExpr{Decl: p.parseFunction()}
+	}
+	if p.match(token.TokenLBrace) {
+		var names []string
+		for p.peek().Type != token.TokenRBrace {
+			names = append(names, p.consume(token.TokenIdent).Literal)
+			if !p.match(token.TokenComma) {
+				break
+			}
+		}
+		p.consume(token.TokenRBrace)
+		p.match(token.TokenSemicolon) // optional semicolon
+		return expression.ExportExpr{Names: names}
+	}
+	decl := p.parseStatement()
+	assign, ok := decl.(expression.AssignExpr)
+	if !ok {
+		panic(&errorexception.UnExpectedTokenError{
+			Message: "Expected a function or an assignment after export",
+		})
+	}
+	if _, ok := assign.Target.(expression.VarExpr); !ok {
+		panic(&errorexception.UnExpectedTokenError{
+			Message: "Only plain variables can be exported",
+		})
+	}
+	return expression.ExportExpr{Decl: assign}
+}
+
+func (p *Parser) consumeContextual(word string) {
+	tok := p.peek()
+	if tok.Type != token.TokenIdent || tok.Literal != word {
+		panic(&errorexception.UnExpectedTokenError{
+			Message: fmt.Sprintf("Expected %s, got %s (%s)", word, tok.Type, tok.Literal),
+		})
+	}
+	p.pos++
+}
+
+var typePatternNames = map[string]bool{
+	"int":      true,
+	"float":    true,
+	"number":   true,
+	"string":   true,
+	"bool":     true,
+	"array":    true,
+	"object":   true,
+	"function": true,
+}
+
+func (p *Parser) parseMatch() expression.Expr {
+	p.consume(token.TokenLParen)
+	subject := p.parseExpr()
+	p.consume(token.TokenRParen)
+	p.consume(token.TokenLBrace)
+
+	var arms []expression.MatchArm
+	for p.peek().Type != token.TokenRBrace && p.peek().Type != token.TokenEOF {
+		arm := expression.MatchArm{Pattern: p.parseMatchPattern()}
+		if p.match(token.TokenIf) {
+			arm.Guard = p.parseExpr()
+		}
+		p.consume(token.TokenArrow)
+		if p.match(token.TokenLBrace) {
+			arm.Body = p.parseBlock()
+			p.consume(token.TokenRBrace)
+		} else {
+			arm.Body = []expression.Expr{p.parseExpr()}
+		}
+		arms = append(arms, arm)
+		p.match(token.TokenComma) // optional comma between arms
+	}
+	p.consume(token.TokenRBrace)
+	return expression.MatchExpr{Subject: subject, Arms: arms}
+}
+
+func (p *Parser) parseMatchPattern() expression.Expr {
+	tok := p.peek()
+	switch tok.Type {
+	case token.TokenIdent:
+		p.advance()
+		if tok.Literal == "_" {
+			return expression.WildcardPattern{}
+		}
+		if typePatternNames[tok.Literal] {
+			return expression.TypePattern{Type: tok.Literal}
+		}
+		return expression.BindingPattern{Name: tok.Literal}
+	case token.TokenLBrace:
+		p.advance()
+		var props []expression.ShapeProperty
+		for p.peek().Type != token.TokenRBrace {
+			keyTok := p.advance()
+			if keyTok.Type != token.TokenIdent && keyTok.Type != token.TokenString {
+				panic("Expected identifier or string as object pattern key")
+			}
+			prop := expression.ShapeProperty{Key: keyTok.Literal}
+			if p.match(token.TokenColon) {
+				prop.Pattern = p.parseMatchPattern()
+			}
+			props = append(props, prop)
+			if !p.match(token.TokenComma) {
+				break
+			}
+		}
+		p.consume(token.TokenRBrace)
+		return expression.ShapePattern{Properties: props}
+	case token.TokenNull, token.TokenTrue, token.TokenFalse, token.TokenString:
+		return p.parsePrimary()
+	case token.TokenNumber, token.TokenFloat, token.TokenMinus:
+		low := p.parsePrimary()
+		if p.match(token.TokenRange) {
+			return expression.RangePattern{Low: low, High: p.parsePrimary()}
+		}
+		return low
+	default:
+		panic(&errorexception.UnExpectedTokenError{
+			Message: fmt.Sprintf("Unexpected token in match pattern: %s (%s)", tok.Type, tok.Literal),
+		})
+	}
+}
+
 func (p *Parser) parseExpr() expression.Expr {
 	return p.parsePrecedence(0)
 }
@@ -223,6 +487,8 @@ func (p *Parser) parsePrecedence(minPrec int) expression.Expr {
 			return expression.AssignExpr{Target: target, Value: value}
 		case expression.IndexExpr: // array a[0] = 4
 			return expression.AssignExpr{Target: target, Value: value}
+		case expression.ObjectExpr, expression.ArrayExpr: // destructuring { a, b } = obj
+			return expression.AssignExpr{Target: toPattern(target), Value: value}
 		default:
 			panic("Invalid assignment target")
 		}
@@ -256,26 +522,25 @@ func (p *Parser) parsePrimary() expression.Expr {
 		p.advance()
 		expr = expression.StringExpr{Value: tok.Literal}
 	case token.TokenIdent:
+		// concise lambda with a single parameter: x => x * 2
+		if p.peekAt(1).Type == token.TokenArrow {
+			return p.parseArrowFunction()
+		}
 		tok := p.advance()
 		// function call or variable?
 		if p.match(token.TokenLParen) {
-			var args []expression.Expr
-			if p.peek().Type != token.TokenRParen {
-				args = append(args, p.parseExpr())
-				for p.match(token.TokenComma) {
-					args = append(args, p.parseExpr())
-				}
-			}
-			p.consume(token.TokenRParen)
-			expr = expression.FuncCall{Name: tok.Literal, Args: args}
+			expr = expression.FuncCall{Name: tok.Literal, Args: p.parseArgs()}
 		} else {
 			expr = expression.VarExpr{Name: tok.Literal}
 		}
 	case token.TokenLParen:
+		// arrow function: (a, b) => a + b
+		if p.isArrowFunction() {
+			return p.parseArrowFunction()
+		}
 		p.advance()
-		expr := p.parseExpr()
+		expr = p.parseExpr()
 		p.consume(token.TokenRParen)
-		return expr
 	case token.TokenLBrace:
 		expr = p.parseObjectLiteral()
 	case token.TokenMinus:
@@ -283,11 +548,14 @@ func (p *Parser) parsePrimary() expression.Expr {
 		p.advance()
 		right := p.parsePrimary()
 		expr = expression.BinaryExpr{Left: expression.NumberExpr{Value: 0}, Operator: token.Token{Type: token.TokenMinus}, Right: right}
+	case token.TokenMatch:
+		p.advance()
+		expr = p.parseMatch()
 	case token.TokenFn:
 		p.advance()
 		return p.parseAnonFunction()
 	case token.TokenLBracket:
-		return p.parseArrayLiteral()
+		expr = p.parseArrayLiteral()
 		// default:
 		// 	panic("Unexpected token: " + tok.Literal)
 	}
@@ -297,12 +565,41 @@ func (p *Parser) parsePrimary() expression.Expr {
 		case token.TokenDot:
 			// for object property assignment
 			p.advance()
-			propTok := p.consume(token.TokenIdent)
+			propTok := p.consumePropertyName()
 			expr = expression.MemberExpr{
 				Object:   expr,
 				Property: propTok.Literal,
 			}
 
+		case token.TokenOptionalDot:
+			// null-safe access: a?.x, a?.[i] and f?.(args)
+			p.advance()
+			switch p.peek().Type {
+			case token.TokenLBracket:
+				p.advance()
+				index := p.parseExpr()
+				p.consume(token.TokenRBracket)
+				expr = expression.IndexExpr{
+					Array:    expr,
+					Index:    index,
+					Optional: true,
+				}
+			case token.TokenLParen:
+				p.advance()
+				expr = expression.CallExpr{
+					Callee:   expr,
+					Args:     p.parseArgs(),
+					Optional: true,
+				}
+			default:
+				propTok := p.consumePropertyName()
+				expr = expression.MemberExpr{
+					Object:   expr,
+					Property: propTok.Literal,
+					Optional: true,
+				}
+			}
+
 		case token.TokenLBracket:
 			// for array index assignment
 			p.advance()
@@ -316,17 +613,9 @@ func (p *Parser) parsePrimary() expression.Expr {
 		case token.TokenLParen:
 			// support calling function expressions: (fn(x){...})(5)
 			p.advance()
-			var args []expression.Expr
-			if p.peek().Type != token.TokenRParen {
-				args = append(args, p.parseExpr())
-				for p.match(token.TokenComma) {
-					args = append(args, p.parseExpr())
-				}
-			}
-			p.consume(token.TokenRParen)
 			expr = expression.CallExpr{
 				Callee: expr,
-				Args:   args,
+				Args:   p.parseArgs(),
 			}
 		default:
 			return expr
@@ -334,6 +623,29 @@ func (p *Parser) parsePrimary() expression.Expr {
 	}
 }
 
+// parseArgs parses a call's argument list after the opening '(' up to and
+// including the closing ')'.
+// consumePropertyName consumes the name after `.` or `?.`, which may be a
+// reserved word.
+func (p *Parser) consumePropertyName() token.Token {
+	if keywords[p.peek().Type] {
+		return p.advance()
+	}
+	return p.consume(token.TokenIdent)
+}
+
+func (p *Parser) parseArgs() []expression.Expr {
+	var args []expression.Expr
+	if p.peek().Type != token.TokenRParen {
+		args = append(args, p.parseExpr())
+		for p.match(token.TokenComma) {
+			args = append(args, p.parseExpr())
+		}
+	}
+	p.consume(token.TokenRParen)
+	return args
+}
+
 func (p *Parser) parseTemplateString(parts []token.Token) expression.Expr {
 	var exprParts []expression.Expr
 
@@ -355,12 +667,28 @@ func (p *Parser) parseTemplateString(parts []token.Token) expression.Expr {
 func (p *Parser) parseObjectLiteral() expression.Expr {
 	p.consume(token.TokenLBrace) // consume '{'
 
-	pairs := make(map[string]expression.Expr)
+	var pairs []expression.ObjectPair
 
 	for p.peek().Type != token.TokenRBrace {
+		// Spread: { ...base }
+		if p.match(token.TokenSpread) {
+			pairs = append(pairs, expression.ObjectPair{Value: expression.SpreadExpr{Value: p.parseExpr()}})
+			if !p.match(token.TokenComma) {
+				break
+			}
+			continue
+		}
+
 		// Parse key
 		var key string
-		if p.peek().Type == token.TokenIdent {
+		keyword := keywords[p.peek().Type]
+		if keyword {
+			// a reserved word has no shorthand, it always takes a value
+			key = p.advance().Literal
+			if p.peek().Type != token.TokenColon {
+				panic(fmt.Sprintf("Expected ':' after object key %s", key))
+			}
+		} else if p.peek().Type == token.TokenIdent {
 			key = p.peek().Literal
 			p.advance()
 		} else if p.peek().Type == token.TokenString {
@@ -370,12 +698,23 @@ func (p *Parser) parseObjectLiteral() expression.Expr {
 			panic("Expected identifier or string as object key")
 		}
 
-		p.consume(token.TokenColon) // consume ':'
+		var value expression.Expr
+		switch p.peek().Type {
+		case token.TokenComma, token.TokenRBrace:
+			// shorthand { name } is { name: name }
+			value = expression.VarExpr{Name: key}
+		case token.TokenAssign:
+			// shorthand with default { name = "anon" }, only valid as a pattern
+			p.advance()
+			value = expression.AssignExpr{Target: expression.VarExpr{Name: key}, Value: p.parseExpr()}
+		default:
+			p.consume(token.TokenColon) // consume ':'
 
-		// Parse value expression
-		value := p.parseExpr()
+			// Parse value expression
+			value = p.parseExpr()
+		}
 
-		pairs[key] = value
+		pairs = append(pairs, expression.ObjectPair{Key: key, Value: value})
 
 		if !p.match(token.TokenComma) {
 			break
@@ -391,15 +730,68 @@ func (p *Parser) parseArrayLiteral() expression.Expr {
 	p.consume(token.TokenLBracket)
 	var elements []expression.Expr
 	if p.peek().Type != token.TokenRBracket {
-		elements = append(elements, p.parseExpr())
+		elements = append(elements, p.parseArrayElement())
 		for p.match(token.TokenComma) {
-			elements = append(elements, p.parseExpr())
+			elements = append(elements, p.parseArrayElement())
 		}
 	}
 	p.consume(token.TokenRBracket)
 	return expression.ArrayExpr{Elements: elements}
 }
 
+func (p *Parser) parseArrayElement() expression.Expr {
+	if p.match(token.TokenSpread) {
+		return expression.SpreadExpr{Value: p.parseExpr()}
+	}
+	return p.parseExpr()
+}
+
+// toPattern turns an object or array literal parsed on the left of '=' (or
+// as a parameter) into a destructuring pattern. `x = 1` inside the literal
+// becomes a default and `...x` the rest target.
+func toPattern(expr expression.Expr) expression.Expr {
+	switch e := expr.(type) {
+	case expression.VarExpr, expression.MemberExpr, expression.IndexExpr,
+		expression.ObjectPattern, expression.ArrayPattern:
+		return e
+	case expression.ObjectExpr:
+		pattern := expression.ObjectPattern{}
+		for i, pair := range e.Pairs {
+			if spread, ok := pair.Value.(expression.SpreadExpr); ok {
+				if i != len(e.Pairs)-1 {
+					panic("Rest element must be last in a destructuring pattern")
+				}
+				pattern.Rest = toPattern(spread.Value)
+				continue
+			}
+			pattern.Properties = append(pattern.Properties, toPatternElement(pair.Key, pair.Value))
+		}
+		return pattern
+	case expression.ArrayExpr:
+		pattern := expression.ArrayPattern{}
+		for i, element := range e.Elements {
+			if spread, ok := element.(expression.SpreadExpr); ok {
+				if i != len(e.Elements)-1 {
+					panic("Rest element must be last in a destructuring pattern")
+				}
+				pattern.Rest = toPattern(spread.Value)
+				continue
+			}
+			pattern.Elements = append(pattern.Elements, toPatternElement("", element))
+		}
+		return pattern
+	default:
+		panic("Invalid destructuring target")
+	}
+}
+
+func toPatternElement(key string, value expression.Expr) expression.PatternElement {
+	if assign, ok := value.(expression.AssignExpr); ok {
+		return expression.PatternElement{Key: key, Target: toPattern(assign.Target), Default: assign.Value}
+	}
+	return expression.PatternElement{Key: key, Target: toPattern(value)}
+}
+
 func NewParserFromString(input string) *Parser {
 	tokens := lexer.Lex(input)
 	return NewParser(tokens)
@@ -0,0 +1,378 @@
+package resolver
+
+import (
+	"sort"
+
+	"theparadance.com/quan-lang/src/expression"
+)
+
+// Result is a resolved program.
+type Result struct {
+	AST []expression.Expr
+	// FreeNames are the names the program uses but never binds, sorted.
+	// They must be provided by the host as variables, functions or
+	// builtins, otherwise they are undefined.
+	FreeNames []string
+}
+
+// Resolve runs between parsing and evaluation. Inside every function it
+// gives the parameters and the variables the function assigns a slot in the
+// function's frame, and replaces references to them with LocalExpr, so the
+// interpreter reads them from a slice instead of walking maps.
+//
+// Scoping stays dynamic: a name that is not a local of the function it is
+// used in is still looked up at run time in the calling functions and the
+// program scope. Variables bound inside a `match` arm live in the arm's own
+// scope and are looked up by name as well.
+func Resolve(ast []expression.Expr) Result {
+	r := &resolver{
+		bound: make(map[string]bool),
+		used:  make(map[string]bool),
+	}
+	resolved := r.block(ast)
+
+	var free []string
+	for name := range r.used {
+		if !r.bound[name] {
+			free = append(free, name)
+		}
+	}
+	sort.Strings(free)
+	return Result{AST: resolved, FreeNames: free}
+}
+
+// Undefined returns the free names for which defined reports false.
+func (result Result) Undefined(defined func(name string) bool) []string {
+	var undefined []string
+	for _, name := range result.FreeNames {
+		if !defined(name) {
+			undefined = append(undefined, name)
+		}
+	}
+	return undefined
+}
+
+type resolver struct {
+	bound map[string]bool // names bound anywhere in the program
+	used  map[string]bool // names read or called anywhere in the program
+	fn    *function       // nil at the top level
+}
+
+type function struct {
+	scope *expression.Scope
+	arms  []map[string]bool // names bound by the enclosing match arms, innermost last
+}
+
+func (r *resolver) block(stmts []expression.Expr) []expression.Expr {
+	if stmts == nil {
+		return nil
+	}
+	result := make([]expression.Expr, len(stmts))
+	for i, stmt := range stmts {
+		result[i] = r.expr(stmt)
+	}
+	return result
+}
+
+func (r *resolver) optional(expr expression.Expr) expression.Expr {
+	if expr == nil {
+		return nil
+	}
+	return r.expr(expr)
+}
+
+func (r *resolver) expr(expr expression.Expr) expression.Expr {
+	switch e := expr.(type) {
+	case expression.VarExpr:
+		r.used[e.Name] = true
+		return r.variable(e.Name)
+	case expression.AssignExpr:
+		value := r.expr(e.Value)
+		return expression.AssignExpr{Target: r.target(e.Target), Value: value}
+	case expression.TemplateStringExpr:
+		return expression.TemplateStringExpr{Value: r.block(e.Value)}
+	case expression.BinaryExpr:
+		return expression.BinaryExpr{Left: r.expr(e.Left), Operator: e.Operator, Right: r.expr(e.Right)}
+	case expression.IfExpr:
+		return expression.IfExpr{Condition: r.expr(e.Condition), Then: r.block(e.Then), Else: r.block(e.Else)}
+	case expression.TernaryExpr:
+		return expression.TernaryExpr{Condition: r.expr(e.Condition), TrueValue: r.expr(e.TrueValue), FalseValue: r.expr(e.FalseValue)}
+	case expression.MatchExpr:
+		return r.match(e)
+	case expression.FuncDef:
+		return r.function(e)
+	case expression.FuncCall:
+		r.used[e.Name] = true
+		return expression.FuncCall{Name: e.Name, Args: r.block(e.Args)}
+	case expression.CallExpr:
+		return expression.CallExpr{Callee: r.expr(e.Callee), Args: r.block(e.Args), Optional: e.Optional}
+	case expression.ReturnExpr:
+		return expression.ReturnExpr{Value: r.optional(e.Value)}
+	case expression.ObjectExpr:
+		pairs := make([]expression.ObjectPair, len(e.Pairs))
+		for i, pair := range e.Pairs {
+			pairs[i] = expression.ObjectPair{Key: pair.Key, Value: r.expr(pair.Value)}
+		}
+		return expression.ObjectExpr{Pairs: pairs}
+	case expression.MemberExpr:
+		return expression.MemberExpr{Object: r.expr(e.Object), Property: e.Property, Optional: e.Optional}
+	case expression.IndexExpr:
+		return expression.IndexExpr{Array: r.expr(e.Array), Index: r.expr(e.Index), Optional: e.Optional}
+	case expression.ArrayExpr:
+		return expression.ArrayExpr{Elements: r.block(e.Elements)}
+	case expression.SpreadExpr:
+		return expression.SpreadExpr{Value: r.expr(e.Value)}
+	case expression.ImportExpr:
+		names := make([]expression.ImportName, len(e.Names))
+		for i, name := range e.Names {
+			r.bound[name.Alias] = true
+			names[i] = name
+		}
+		return expression.ImportExpr{Names: names, Path: e.Path}
+	case expression.ExportExpr:
+		for _, name := range e.Names {
+			r.used[name] = true
+		}
+		return expression.ExportExpr{Decl: r.optional(e.Decl), Names: e.Names}
+	default:
+		// literals
+		return expr
+	}
+}
+
+// variable returns the reference to a variable named in the current scope.
+func (r *resolver) variable(name string) expression.Expr {
+	if r.fn == nil {
+		return expression.VarExpr{Name: name}
+	}
+	depth := 0
+	for i := len(r.fn.arms) - 1; i >= 0; i-- {
+		if r.fn.arms[i][name] {
+			return expression.VarExpr{Name: name}
+		}
+		depth++
+	}
+	if slot, ok := r.fn.scope.Slots[name]; ok {
+		return expression.LocalExpr{Name: name, Depth: depth, Slot: slot}
+	}
+	return expression.VarExpr{Name: name}
+}
+
+func (r *resolver) target(target expression.Expr) expression.Expr {
+	switch t := target.(type) {
+	case expression.VarExpr:
+		r.bound[t.Name] = true
+		return r.variable(t.Name)
+	case expression.ObjectPattern:
+		return expression.ObjectPattern{Properties: r.patternElements(t.Properties), Rest: r.optionalTarget(t.Rest)}
+	case expression.ArrayPattern:
+		return expression.ArrayPattern{Elements: r.patternElements(t.Elements), Rest: r.optionalTarget(t.Rest)}
+	default:
+		return r.expr(target)
+	}
+}
+
+func (r *resolver) optionalTarget(target expression.Expr) expression.Expr {
+	if target == nil {
+		return nil
+	}
+	return r.target(target)
+}
+
+func (r *resolver) patternElements(elements []expression.PatternElement) []expression.PatternElement {
+	result := make([]expression.PatternElement, len(elements))
+	for i, element := range elements {
+		result[i] = expression.PatternElement{
+			Key:     element.Key,
+			Target:  r.target(element.Target),
+			Default: r.optional(element.Default),
+		}
+	}
+	return result
+}
+
+func (r *resolver) function(def expression.FuncDef) expression.Expr {
+	if def.Name != "" {
+		r.bound[def.Name] = true
+	}
+
+	scope := &expression.Scope{Slots: make(map[string]int)}
+	declare := func(name string) {
+		if _, ok := scope.Slots[name]; !ok {
+			scope.Slots[name] = len(scope.Names)
+			scope.Names = append(scope.Names, name)
+		}
+	}
+	for _, param := range def.Params {
+		r.bound[param] = true
+		declare(param)
+	}
+	for _, stmt := range def.Body {
+		collect(stmt, declare)
+	}
+
+	outer := r.fn
+	r.fn = &function{scope: scope}
+	body := r.block(def.Body)
+	r.fn = outer
+
+	return expression.FuncDef{Name: def.Name, Params: def.Params, Body: body, Scope: scope}
+}
+
+func (r *resolver) match(e expression.MatchExpr) expression.Expr {
+	subject := r.expr(e.Subject)
+	arms := make([]expression.MatchArm, len(e.Arms))
+	for i, arm := range e.Arms {
+		names := make(map[string]bool)
+		declare := func(name string) { names[name] = true }
+		collectPattern(arm.Pattern, declare)
+		if arm.Guard != nil {
+			collect(arm.Guard, declare)
+		}
+		for _, stmt := range arm.Body {
+			collect(stmt, declare)
+		}
+		for name := range names {
+			r.bound[name] = true
+		}
+
+		if r.fn != nil {
+			r.fn.arms = append(r.fn.arms, names)
+		}
+		arms[i] = expression.MatchArm{
+			Pattern: r.pattern(arm.Pattern),
+			Guard:   r.optional(arm.Guard),
+			Body:    r.block(arm.Body),
+		}
+		if r.fn != nil {
+			r.fn.arms = r.fn.arms[:len(r.fn.arms)-1]
+		}
+	}
+	return expression.MatchExpr{Subject: subject, Arms: arms}
+}
+
+func (r *resolver) pattern(pattern expression.Expr) expression.Expr {
+	switch p := pattern.(type) {
+	case expression.WildcardPattern, expression.TypePattern, expression.BindingPattern:
+		return pattern
+	case expression.RangePattern:
+		return expression.RangePattern{Low: r.expr(p.Low), High: r.expr(p.High)}
+	case expression.ShapePattern:
+		properties := make([]expression.ShapeProperty, len(p.Properties))
+		for i, prop := range p.Properties {
+			properties[i] = expression.ShapeProperty{Key: prop.Key}
+			if prop.Pattern != nil {
+				properties[i].Pattern = r.pattern(prop.Pattern)
+			}
+		}
+		return expression.ShapePattern{Properties: properties}
+	default:
+		return r.expr(pattern)
+	}
+}
+
+// collect calls declare for every variable that expr assigns in the scope
+// it runs in. It does not look into function bodies, which have their own
+// frame, nor into match arms, which have their own scope.
+func collect(expr expression.Expr, declare func(string)) {
+	switch e := expr.(type) {
+	case expression.AssignExpr:
+		collectTarget(e.Target, declare)
+		collect(e.Value, declare)
+	case expression.ImportExpr:
+		for _, name := range e.Names {
+			declare(name.Alias)
+		}
+	case expression.ExportExpr:
+		if e.Decl != nil {
+			collect(e.Decl, declare)
+		}
+	case expression.TemplateStringExpr:
+		collectAll(e.Value, declare)
+	case expression.BinaryExpr:
+		collect(e.Left, declare)
+		collect(e.Right, declare)
+	case expression.IfExpr:
+		collect(e.Condition, declare)
+		collectAll(e.Then, declare)
+		collectAll(e.Else, declare)
+	case expression.TernaryExpr:
+		collect(e.Condition, declare)
+		collect(e.TrueValue, declare)
+		collect(e.FalseValue, declare)
+	case expression.MatchExpr:
+		collect(e.Subject, declare)
+	case expression.FuncCall:
+		collectAll(e.Args, declare)
+	case expression.CallExpr:
+		collect(e.Callee, declare)
+		collectAll(e.Args, declare)
+	case expression.ReturnExpr:
+		if e.Value != nil {
+			collect(e.Value, declare)
+		}
+	case expression.ObjectExpr:
+		for _, pair := range e.Pairs {
+			collect(pair.Value, declare)
+		}
+	case expression.MemberExpr:
+		collect(e.Object, declare)
+	case expression.IndexExpr:
+		collect(e.Array, declare)
+		collect(e.Index, declare)
+	case expression.ArrayExpr:
+		collectAll(e.Elements, declare)
+	case expression.SpreadExpr:
+		collect(e.Value, declare)
+	}
+}
+
+func collectAll(exprs []expression.Expr, declare func(string)) {
+	for _, expr := range exprs {
+		collect(expr, declare)
+	}
+}
+
+func collectTarget(target expression.Expr, declare func(string)) {
+	switch t := target.(type) {
+	case expression.VarExpr:
+		declare(t.Name)
+	case expression.ObjectPattern:
+		collectElements(t.Properties, declare)
+		if t.Rest != nil {
+			collectTarget(t.Rest, declare)
+		}
+	case expression.ArrayPattern:
+		collectElements(t.Elements, declare)
+		if t.Rest != nil {
+			collectTarget(t.Rest, declare)
+		}
+	default:
+		collect(target, declare)
+	}
+}
+
+func collectElements(elements []expression.PatternElement, declare func(string)) {
+	for _, element := range elements {
+		collectTarget(element.Target, declare)
+		if element.Default != nil {
+			collect(element.Default, declare)
+		}
+	}
+}
+
+// collectPattern declares the names a match pattern binds.
+func collectPattern(pattern expression.Expr, declare func(string)) {
+	switch p := pattern.(type) {
+	case expression.BindingPattern:
+		declare(p.Name)
+	case expression.ShapePattern:
+		for _, prop := range p.Properties {
+			if prop.Pattern == nil {
+				declare(prop.Key)
+			} else {
+				collectPattern(prop.Pattern, declare)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package systemconsole
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LEVEL_LOG   = "log"
+	LEVEL_ERROR = "error"
+
+	errorPrefix = "[Error]: "
+)
+
+// Entry is one write to a StreamSystemConsole.
+type Entry struct {
+	Level     string    `json:"level"` // LEVEL_LOG, or LEVEL_ERROR for errors the engine reports
+	Text      string    `json:"text"`  // ends with a newline when written by Println
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// StreamSystemConsole hands every Print and Println to a callback as soon as
+// it happens, so hosts can show the output of long-running scripts live.
+// String still returns everything written, like VirtualSystemConsole.
+type StreamSystemConsole struct {
+	mutex   sync.Mutex
+	emit    func(Entry)
+	builder strings.Builder
+}
+
+// NewStreamSystemConsole creates a console that calls emit with every entry.
+// emit is called synchronously by the goroutine running the script, without
+// the console's lock held, so it may call String or Clear. Entries written
+// from several goroutines at once may reach emit in a different order than
+// String shows them.
+func NewStreamSystemConsole(emit func(Entry)) *StreamSystemConsole {
+	if emit == nil {
+		panic("systemconsole: NewStreamSystemConsole called with a nil emit")
+	}
+	return &StreamSystemConsole{emit: emit}
+}
+
+// NewWriterSystemConsole creates a console that writes every entry to w as a
+// line of JSON. Write errors are ignored.
+func NewWriterSystemConsole(w io.Writer) *StreamSystemConsole {
+	var mutex sync.Mutex
+	encoder := json.NewEncoder(w)
+	return NewStreamSystemConsole(func(entry Entry) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		_ = encoder.Encode(entry)
+	})
+}
+
+func (streamConsole *StreamSystemConsole) Println(args ...any) {
+	streamConsole.write(LEVEL_LOG, Format(args...)+"\n", "")
+}
+
+func (streamConsole *StreamSystemConsole) Print(args ...any) {
+	streamConsole.write(LEVEL_LOG, Format(args...), "")
+}
+
+// Errorln writes an error the engine reports. String shows it with the
+// "[Error]: " prefix of other consoles, the entry without it.
+func (streamConsole *StreamSystemConsole) Errorln(args ...any) {
+	streamConsole.write(LEVEL_ERROR, Format(args...)+"\n", errorPrefix)
+}
+
+func (streamConsole *StreamSystemConsole) write(level string, text string, prefix string) {
+	streamConsole.mutex.Lock()
+	streamConsole.builder.WriteString(prefix + text)
+	streamConsole.mutex.Unlock()
+	streamConsole.emit(Entry{Level: level, Text: text, Timestamp: time.Now()})
+}
+
+func (streamConsole *StreamSystemConsole) String() string {
+	streamConsole.mutex.Lock()
+	defer streamConsole.mutex.Unlock()
+	return streamConsole.builder.String()
+}
+
+func (streamConsole *StreamSystemConsole) Clear() {
+	streamConsole.mutex.Lock()
+	defer streamConsole.mutex.Unlock()
+	streamConsole.builder.Reset()
+}
+
+// PrintError writes an error the engine reports to console, at LEVEL_ERROR
+// on consoles that record levels.
+func PrintError(console SystemConsole, args ...any) {
+	if errorConsole, ok := console.(interface{ Errorln(args ...any) }); ok {
+		errorConsole.Errorln(args...)
+		return
+	}
+	console.Println(append([]any{errorPrefix}, args...)...)
+}
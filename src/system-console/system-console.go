@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"theparadance.com/quan-lang/src/object"
 )
 
 type SystemConsole interface {
@@ -29,36 +31,44 @@ func (virtualConsole *VirtualSystemConsole) Println(args ...any) {
 }
 
 func (virtualConsole *VirtualSystemConsole) Print(args ...any) {
+	virtualConsole.builder.WriteString(Format(args...))
+}
+
+// Format renders args the way consoles print them, without separators.
+func Format(args ...any) string {
 	defer func() {
 		if r := recover(); r != nil {
-			panic("Error in VirtualSystemConsole.Print")
+			panic("Error in SystemConsole.Print")
 		}
 	}()
 
+	var builder strings.Builder
 	for _, arg := range args {
 		switch v := arg.(type) {
 		case string:
-			virtualConsole.builder.WriteString(v)
+			builder.WriteString(v)
+		case nil, *object.Null:
+			builder.WriteString("null")
 		case bool:
-			virtualConsole.builder.WriteString(strconv.FormatBool(v))
+			builder.WriteString(strconv.FormatBool(v))
 		case int:
-			virtualConsole.builder.WriteString(strconv.Itoa(v))
+			builder.WriteString(strconv.Itoa(v))
 		case float64:
-			virtualConsole.builder.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+			builder.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
 		case map[string]interface{}:
 			json, _ := MapToPrettyJSON(v)
-			virtualConsole.builder.WriteString(json)
-		case []interface{}:
-			strs := []string{}
-			for _, item := range v {
-				strs = append(strs, fmt.Sprintf("%v", item))
-			}
-			virtualConsole.builder.WriteString("[" + strings.Join(strs, ", ") + "]")
+			builder.WriteString(json)
+		case *object.Object:
+			json, _ := ObjectToPrettyJSON(v)
+			builder.WriteString(json)
+		case *object.Array:
+			builder.WriteString("[" + strings.Join(object.SprintItems(v), ", ") + "]")
 		default:
-			virtualConsole.builder.WriteString(fmt.Sprintf("%v", v))
-			// virtualConsole.builder.WriteString(v.(string)) // Assuming all other types can be converted to string
+			builder.WriteString(fmt.Sprintf("%v", v))
+			// builder.WriteString(v.(string)) // Assuming all other types can be converted to string
 		}
 	}
+	return builder.String()
 }
 
 func (virtualConsole *VirtualSystemConsole) String() string {
@@ -69,6 +79,14 @@ func (virtualConsole *VirtualSystemConsole) Clear() {
 	virtualConsole.builder.Reset()
 }
 
+func ObjectToPrettyJSON(o *object.Object) (string, error) {
+	bytes, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
 func MapToPrettyJSON(m map[string]interface{}) (string, error) {
 	bytes, err := json.MarshalIndent(m, "", "  ") // indent with 2 spaces
 	if err != nil {
@@ -22,6 +22,10 @@ const (
 	TokenElse   TokenType = "ELSE"
 	TokenFn     TokenType = "FN"
 	TokenReturn TokenType = "RETURN"
+	TokenIn     TokenType = "IN"
+	TokenMatch  TokenType = "MATCH"
+	TokenImport TokenType = "IMPORT"
+	TokenExport TokenType = "EXPORT"
 
 	// Boolean literals
 	TokenTrue  = "TRUE"
@@ -31,6 +35,9 @@ const (
 
 	TokenNull = "NULL"
 
+	TokenNullish     TokenType = "NULLISH"      // ??
+	TokenOptionalDot TokenType = "OPTIONAL_DOT" // ?.
+
 	// Operators and punctuation
 	TokenPlus  TokenType = "PLUS"
 	TokenMinus TokenType = "MINUS"
@@ -40,6 +47,7 @@ const (
 	TokenCaret TokenType = "CARET"
 
 	TokenAssign TokenType = "ASSIGN"
+	TokenArrow  TokenType = "ARROW" // =>
 
 	// array
 	TokenLBracket TokenType = "LBRACKET" // [
@@ -61,4 +69,6 @@ const (
 	TokenSemicolon TokenType = "SEMICOLON"
 	TokenColon     TokenType = "COLON"
 	TokenDot       TokenType = "DOT"
+	TokenSpread    TokenType = "SPREAD" // ...
+	TokenRange     TokenType = "RANGE"  // ..
 )
@@ -0,0 +1,506 @@
+package vm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/token"
+)
+
+// Function is a compiled function body, or the top level of a program.
+//
+// Variables assigned in a function, and its parameters, live in numbered
+// local slots. The language is dynamically scoped, so any other name is
+// looked up at run time in the calling frames, then in the program scope.
+type Function struct {
+	Name      string
+	Def       expression.FuncDef
+	Code      []byte
+	Constants []interface{}
+	NumParams int
+	VarSlots  map[string]int
+	VarNames  []string
+	FuncSlots map[string]int // named functions defined in the body
+	NumVars   int
+	NumFuncs  int
+}
+
+func (fn *Function) ParamCount() int {
+	return fn.NumParams
+}
+
+// Definition returns the function definition fn was compiled from.
+func (fn *Function) Definition() expression.FuncDef {
+	return fn.Def
+}
+
+// String and MarshalJSON present fn as its definition, so a compiled function
+// prints and serialises exactly like the tree-walk engine's FuncDef.
+func (fn *Function) String() string {
+	return fmt.Sprint(fn.Def)
+}
+
+func (fn *Function) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fn.Def)
+}
+
+// Program is the compiled top level of a script.
+type Program struct {
+	Main *Function
+	// NestedFuncs is false when no function defines a named function of its
+	// own, which lets calls by name skip searching the calling frames.
+	NestedFuncs bool
+}
+
+// UnsupportedError is returned by Compile for programs using a feature the
+// VM does not implement. Such programs run in the tree-walking interpreter.
+type UnsupportedError struct {
+	Feature string
+}
+
+func (e *UnsupportedError) Error() string {
+	return "the bytecode VM does not support " + e.Feature
+}
+
+type compiler struct {
+	program *Program
+	fn      *Function
+	main    bool
+	names   map[string]int
+	// jumps to the end of the current top-level statement, used by a
+	// `return` outside of any function
+	statementEnd []int
+}
+
+// Compile translates a parsed program into bytecode.
+func Compile(ast []expression.Expr) (program *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if unsupported, ok := r.(*UnsupportedError); ok {
+				program, err = nil, unsupported
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	program = &Program{}
+	c := newCompiler(program, &Function{Name: "<main>"}, true)
+	for _, stmt := range ast {
+		c.statementEnd = nil
+		c.compileStatement(stmt)
+		for _, jump := range c.statementEnd {
+			c.patchJump(jump)
+		}
+	}
+	c.emit(OpNull)
+	c.emit(OpReturn)
+	program.Main = c.fn
+	return program, nil
+}
+
+func newCompiler(program *Program, fn *Function, main bool) *compiler {
+	fn.VarSlots = make(map[string]int)
+	fn.FuncSlots = make(map[string]int)
+	return &compiler{
+		program: program,
+		fn:      fn,
+		main:    main,
+		names:   make(map[string]int),
+	}
+}
+
+func (c *compiler) compileFunction(def expression.FuncDef) *Function {
+	fn := &Function{Name: def.Name, Def: def, NumParams: len(def.Params)}
+	fc := newCompiler(c.program, fn, false)
+	for _, param := range def.Params {
+		fc.declareVar(param)
+	}
+	for _, stmt := range def.Body {
+		fc.collectLocals(stmt)
+	}
+	for _, stmt := range def.Body {
+		fc.compileStatement(stmt)
+	}
+	fc.emit(OpNull)
+	fc.emit(OpReturn)
+	return fn
+}
+
+func (c *compiler) declareVar(name string) {
+	if _, ok := c.fn.VarSlots[name]; !ok {
+		c.fn.VarSlots[name] = c.fn.NumVars
+		c.fn.VarNames = append(c.fn.VarNames, name)
+		c.fn.NumVars++
+	}
+}
+
+// collectLocals assigns slots to every variable the function body assigns
+// and every named function it defines, wherever they appear: `if` blocks do
+// not introduce a scope.
+func (c *compiler) collectLocals(expr expression.Expr) {
+	switch e := expr.(type) {
+	case expression.AssignExpr:
+		switch target := e.Target.(type) {
+		case expression.VarExpr:
+			c.declareVar(target.Name)
+		case expression.LocalExpr:
+			c.declareVar(target.Name)
+		}
+		c.collectLocals(e.Target)
+		c.collectLocals(e.Value)
+	case expression.FuncDef:
+		if e.Name != "" {
+			if _, ok := c.fn.FuncSlots[e.Name]; !ok {
+				c.fn.FuncSlots[e.Name] = c.fn.NumFuncs
+				c.fn.NumFuncs++
+			}
+			c.program.NestedFuncs = true
+		}
+	case expression.IfExpr:
+		c.collectLocals(e.Condition)
+		c.collectAll(e.Then)
+		c.collectAll(e.Else)
+	case expression.BinaryExpr:
+		c.collectLocals(e.Left)
+		c.collectLocals(e.Right)
+	case expression.TernaryExpr:
+		c.collectLocals(e.Condition)
+		c.collectLocals(e.TrueValue)
+		c.collectLocals(e.FalseValue)
+	case expression.ReturnExpr:
+		if e.Value != nil {
+			c.collectLocals(e.Value)
+		}
+	case expression.FuncCall:
+		c.collectAll(e.Args)
+	case expression.CallExpr:
+		c.collectLocals(e.Callee)
+		c.collectAll(e.Args)
+	case expression.MemberExpr:
+		c.collectLocals(e.Object)
+	case expression.IndexExpr:
+		c.collectLocals(e.Array)
+		c.collectLocals(e.Index)
+	case expression.ArrayExpr:
+		c.collectAll(e.Elements)
+	case expression.ObjectExpr:
+		for _, pair := range e.Pairs {
+			c.collectLocals(pair.Value)
+		}
+	case expression.SpreadExpr:
+		c.collectLocals(e.Value)
+	case expression.TemplateStringExpr:
+		c.collectAll(e.Value)
+	}
+}
+
+func (c *compiler) collectAll(exprs []expression.Expr) {
+	for _, expr := range exprs {
+		c.collectLocals(expr)
+	}
+}
+
+func (c *compiler) compileBlock(stmts []expression.Expr) {
+	for _, stmt := range stmts {
+		c.compileStatement(stmt)
+	}
+}
+
+func (c *compiler) compileStatement(stmt expression.Expr) {
+	switch e := stmt.(type) {
+	case expression.FuncDef:
+		if e.Name == "" {
+			c.compileExpr(e)
+			c.emit(OpPop)
+			return
+		}
+		c.defineFunction(e)
+	case expression.IfExpr:
+		c.compileExpr(e.Condition)
+		elseJump := c.emitJump(OpJumpIfFalse)
+		c.compileBlock(e.Then)
+		endJump := c.emitJump(OpJump)
+		c.patchJump(elseJump)
+		c.compileBlock(e.Else)
+		c.patchJump(endJump)
+	case expression.ReturnExpr:
+		if e.Value == nil {
+			c.emit(OpNull)
+		} else {
+			c.compileExpr(e.Value)
+		}
+		if c.main {
+			// a top-level return only ends the statement it appears in
+			c.emit(OpPop)
+			c.statementEnd = append(c.statementEnd, c.emitJump(OpJump))
+			return
+		}
+		c.emit(OpReturn)
+	default:
+		c.compileExpr(stmt)
+		c.emit(OpPop)
+	}
+}
+
+func (c *compiler) defineFunction(def expression.FuncDef) {
+	proto := c.compileFunction(def)
+	if c.main {
+		c.emit(OpDefineGlobal, c.constant(proto))
+		return
+	}
+	c.emit(OpDefineFunc, c.constant(proto), c.fn.FuncSlots[def.Name])
+}
+
+func (c *compiler) compileExpr(expr expression.Expr) {
+	switch e := expr.(type) {
+	case expression.NullExpr:
+		c.emit(OpNull)
+	case expression.NumberExpr:
+		c.emit(OpConstant, c.constant(e.Value))
+	case expression.StringExpr:
+		c.emit(OpConstant, c.constant(e.Value))
+	case expression.BooleanExpr:
+		c.emit(OpConstant, c.constant(e.Value))
+	case expression.TemplateStringExpr:
+		for _, part := range e.Value {
+			c.compileExpr(part)
+		}
+		c.emit(OpTemplate, len(e.Value))
+	case expression.VarExpr:
+		c.loadVar(e.Name)
+	case expression.LocalExpr:
+		// the VM allocates its own slots
+		c.loadVar(e.Name)
+	case expression.AssignExpr:
+		c.compileExpr(e.Value)
+		c.emit(OpDup)
+		c.store(e.Target, false)
+	case expression.BinaryExpr:
+		c.compileExpr(e.Left)
+		if e.Operator.Type == token.TokenNullish {
+			end := c.emitJump(OpJumpIfNotNull)
+			c.compileExpr(e.Right)
+			c.patchJump(end)
+			return
+		}
+		c.compileExpr(e.Right)
+		c.emit(OpBinary, c.constant(e.Operator))
+	case expression.TernaryExpr:
+		c.compileExpr(e.Condition)
+		elseJump := c.emitJump(OpJumpIfFalse)
+		c.compileExpr(e.TrueValue)
+		endJump := c.emitJump(OpJump)
+		c.patchJump(elseJump)
+		c.compileExpr(e.FalseValue)
+		c.patchJump(endJump)
+	case expression.FuncDef:
+		if e.Name != "" {
+			c.defineFunction(e)
+			c.emit(OpConstant, c.constant(0))
+			return
+		}
+		c.emit(OpConstant, c.constant(c.compileFunction(e)))
+	case expression.FuncCall:
+		c.emit(OpResolveFunc, c.name(e.Name), len(e.Args))
+		for _, arg := range e.Args {
+			c.compileExpr(arg)
+		}
+		c.emit(OpCall, len(e.Args))
+	case expression.MemberExpr, expression.IndexExpr, expression.CallExpr:
+		var shortJumps []int
+		c.compileChain(expr, &shortJumps)
+		for _, jump := range shortJumps {
+			c.patchJump(jump)
+		}
+	case expression.ArrayExpr:
+		spread := false
+		for _, element := range e.Elements {
+			if _, ok := element.(expression.SpreadExpr); ok {
+				spread = true
+			}
+		}
+		if !spread {
+			for _, element := range e.Elements {
+				c.compileExpr(element)
+			}
+			c.emit(OpArray, len(e.Elements))
+			return
+		}
+		c.emit(OpNewArray)
+		for _, element := range e.Elements {
+			if s, ok := element.(expression.SpreadExpr); ok {
+				c.compileExpr(s.Value)
+				c.emit(OpAppendSpread)
+			} else {
+				c.compileExpr(element)
+				c.emit(OpAppend)
+			}
+		}
+	case expression.ObjectExpr:
+		c.emit(OpNewObject)
+		for _, pair := range e.Pairs {
+			if s, ok := pair.Value.(expression.SpreadExpr); ok {
+				c.compileExpr(s.Value)
+				c.emit(OpSpreadObject)
+				continue
+			}
+			c.compileExpr(pair.Value)
+			c.emit(OpSetProperty, c.constant(pair.Key))
+		}
+	case expression.IfExpr, expression.ReturnExpr:
+		c.compileStatement(e)
+		c.emit(OpNull)
+	default:
+		panic(&UnsupportedError{Feature: fmt.Sprintf("%T", expr)})
+	}
+}
+
+// compileChain compiles a member access, index or call and the chain it is
+// part of. Optional links jump to the end of the whole chain with null on
+// the stack, like the interpreter's evalChain.
+func (c *compiler) compileChain(expr expression.Expr, shortJumps *[]int) {
+	switch e := expr.(type) {
+	case expression.MemberExpr:
+		c.compileChain(e.Object, shortJumps)
+		if e.Optional {
+			*shortJumps = append(*shortJumps, c.emitJump(OpShortIfNull))
+		}
+		c.emit(OpGetMember, c.name(e.Property))
+	case expression.IndexExpr:
+		c.compileChain(e.Array, shortJumps)
+		if e.Optional {
+			*shortJumps = append(*shortJumps, c.emitJump(OpShortIfNull))
+		}
+		c.compileExpr(e.Index)
+		c.emit(OpGetIndex)
+	case expression.CallExpr:
+		member, ok := e.Callee.(expression.MemberExpr)
+		if !ok {
+			c.compileChain(e.Callee, shortJumps)
+			if e.Optional {
+				*shortJumps = append(*shortJumps, c.emitJump(OpShortIfNull))
+			}
+			for _, arg := range e.Args {
+				c.compileExpr(arg)
+			}
+			c.emit(OpCall, len(e.Args))
+			return
+		}
+
+		c.compileChain(member.Object, shortJumps)
+		if member.Optional {
+			*shortJumps = append(*shortJumps, c.emitJump(OpShortIfNull))
+		}
+		flags := 0
+		if e.Optional {
+			flags |= optionalCall
+		}
+		*shortJumps = append(*shortJumps, c.emitGetMethod(member.Property, flags))
+		for _, arg := range e.Args {
+			c.compileExpr(arg)
+		}
+		c.emit(OpCallMethod, len(e.Args))
+	default:
+		c.compileExpr(expr)
+	}
+}
+
+func (c *compiler) loadVar(name string) {
+	if slot, ok := c.fn.VarSlots[name]; ok && !c.main {
+		c.emit(OpGetLocal, slot)
+		return
+	}
+	c.emit(OpGetName, c.name(name))
+}
+
+// store pops the top value into an assignment target. With update set a
+// variable is written in the scope that already defines it.
+func (c *compiler) store(target expression.Expr, update bool) {
+	switch t := target.(type) {
+	case expression.VarExpr:
+		c.storeVar(t.Name, update)
+	case expression.LocalExpr:
+		c.storeVar(t.Name, update)
+	case expression.MemberExpr:
+		c.compileExpr(t.Object)
+		c.emit(OpSetMember, c.name(t.Property))
+	case expression.IndexExpr:
+		c.compileExpr(t.Array)
+		c.compileExpr(t.Index)
+		c.emit(OpSetIndex)
+	default:
+		panic(&UnsupportedError{Feature: fmt.Sprintf("assignment to %T", target)})
+	}
+}
+
+func (c *compiler) storeVar(name string, update bool) {
+	slot, local := c.fn.VarSlots[name]
+	switch {
+	case local && !c.main && update:
+		c.emit(OpUpdateLocal, slot)
+	case local && !c.main:
+		c.emit(OpSetLocal, slot)
+	case update:
+		c.emit(OpUpdateName, c.name(name))
+	default:
+		c.emit(OpSetName, c.name(name))
+	}
+}
+
+func (c *compiler) constant(val interface{}) int {
+	c.fn.Constants = append(c.fn.Constants, val)
+	index := len(c.fn.Constants) - 1
+	if index > math.MaxUint16 {
+		panic(&UnsupportedError{Feature: "more than 65536 constants in one function"})
+	}
+	return index
+}
+
+// name returns the constant index of an identifier, shared by every use of
+// the name in the function.
+func (c *compiler) name(name string) int {
+	if index, ok := c.names[name]; ok {
+		return index
+	}
+	index := c.constant(name)
+	c.names[name] = index
+	return index
+}
+
+func (c *compiler) emit(op Opcode, operands ...int) int {
+	offset := len(c.fn.Code)
+	c.fn.Code = append(c.fn.Code, byte(op))
+	for i, kind := range opcodeInfos[op].operands {
+		if kind == u16 {
+			if operands[i] > math.MaxUint16 {
+				panic(&UnsupportedError{Feature: "operands larger than 65535"})
+			}
+			c.fn.Code = binary.BigEndian.AppendUint16(c.fn.Code, uint16(operands[i]))
+		} else {
+			c.fn.Code = binary.BigEndian.AppendUint32(c.fn.Code, uint32(operands[i]))
+		}
+	}
+	return offset
+}
+
+// emitJump emits a jump with a placeholder target and returns the offset of
+// the target operand for patchJump.
+func (c *compiler) emitJump(op Opcode) int {
+	c.emit(op, 0)
+	return len(c.fn.Code) - 4
+}
+
+func (c *compiler) emitGetMethod(name string, flags int) int {
+	c.emit(OpGetMethod, c.name(name), flags, 0)
+	return len(c.fn.Code) - 4
+}
+
+// patchJump points the jump whose target operand is at offset to the
+// current end of the code.
+func (c *compiler) patchJump(offset int) {
+	binary.BigEndian.PutUint32(c.fn.Code[offset:], uint32(len(c.fn.Code)))
+}
@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"theparadance.com/quan-lang/src/token"
+)
+
+type Opcode byte
+
+// Operands follow the opcode in the code stream. Most are 2 byte unsigned
+// integers (constant indexes, slots, counts); jump targets are 4 bytes.
+const (
+	OpConstant      Opcode = iota // const: push constants[const]
+	OpNull                        // push null
+	OpPop                         // drop the top value
+	OpDup                         // duplicate the top value
+	OpGetLocal                    // slot: push a local, falling back to dynamic lookup when unset
+	OpSetLocal                    // slot: pop into a local
+	OpUpdateLocal                 // slot: pop into a local, or the scope that already defines it
+	OpGetName                     // name: push a variable, function or builtin found by name
+	OpSetName                     // name: pop into a variable of the program scope
+	OpUpdateName                  // name: pop into the scope that defines the variable
+	OpDefineFunc                  // proto, slot: bind a named function in the current frame
+	OpDefineGlobal                // proto: bind a named function in the program scope
+	OpBinary                      // operator: pop two operands, push the result
+	OpJump                        // target
+	OpJumpIfFalse                 // target: pop, jump when not truthy
+	OpJumpIfNotNull               // target: keep the top and jump when it is not null, else pop it
+	OpShortIfNull                 // target: when the top is null replace it with null and jump
+	OpGetMember                   // name: pop an object, push its property
+	OpGetIndex                    // pop index and container, push the element
+	OpSetMember                   // name: pop object and value, assign the property
+	OpSetIndex                    // pop index, container and value, assign the element
+	OpGetMethod                   // name, flags, target: push the method to call on the top value
+	OpResolveFunc                 // name, argc: push the function called by name
+	OpCall                        // argc: call callee below the arguments
+	OpCallMethod                  // argc: call a method on the receiver below the callee
+	OpReturn                      // return the top value to the caller
+	OpArray                       // count: pop values into a new array
+	OpNewArray                    // push an empty array
+	OpAppend                      // pop a value and append it to the array below
+	OpAppendSpread                // pop an array and append its elements to the array below
+	OpNewObject                   // push an empty object
+	OpSetProperty                 // name: pop a value and set it on the object below
+	OpSpreadObject                // pop an object and copy its properties to the object below
+	OpTemplate                    // count: pop values and join them into a string
+)
+
+// Flags of OpGetMethod.
+const (
+	optionalMember = 1 << iota // obj?.method(): null receiver short-circuits
+	optionalCall               // obj.method?.(): null method short-circuits
+)
+
+type operandKind int
+
+const (
+	u16 operandKind = 2
+	u32 operandKind = 4
+)
+
+type opcodeInfo struct {
+	name     string
+	operands []operandKind
+}
+
+var opcodeInfos = map[Opcode]opcodeInfo{
+	OpConstant:      {"CONSTANT", []operandKind{u16}},
+	OpNull:          {"NULL", nil},
+	OpPop:           {"POP", nil},
+	OpDup:           {"DUP", nil},
+	OpGetLocal:      {"GET_LOCAL", []operandKind{u16}},
+	OpSetLocal:      {"SET_LOCAL", []operandKind{u16}},
+	OpUpdateLocal:   {"UPDATE_LOCAL", []operandKind{u16}},
+	OpGetName:       {"GET_NAME", []operandKind{u16}},
+	OpSetName:       {"SET_NAME", []operandKind{u16}},
+	OpUpdateName:    {"UPDATE_NAME", []operandKind{u16}},
+	OpDefineFunc:    {"DEFINE_FUNC", []operandKind{u16, u16}},
+	OpDefineGlobal:  {"DEFINE_GLOBAL", []operandKind{u16}},
+	OpBinary:        {"BINARY", []operandKind{u16}},
+	OpJump:          {"JUMP", []operandKind{u32}},
+	OpJumpIfFalse:   {"JUMP_IF_FALSE", []operandKind{u32}},
+	OpJumpIfNotNull: {"JUMP_IF_NOT_NULL", []operandKind{u32}},
+	OpShortIfNull:   {"SHORT_IF_NULL", []operandKind{u32}},
+	OpGetMember:     {"GET_MEMBER", []operandKind{u16}},
+	OpGetIndex:      {"GET_INDEX", nil},
+	OpSetMember:     {"SET_MEMBER", []operandKind{u16}},
+	OpSetIndex:      {"SET_INDEX", nil},
+	OpGetMethod:     {"GET_METHOD", []operandKind{u16, u16, u32}},
+	OpResolveFunc:   {"RESOLVE_FUNC", []operandKind{u16, u16}},
+	OpCall:          {"CALL", []operandKind{u16}},
+	OpCallMethod:    {"CALL_METHOD", []operandKind{u16}},
+	OpReturn:        {"RETURN", nil},
+	OpArray:         {"ARRAY", []operandKind{u16}},
+	OpNewArray:      {"NEW_ARRAY", nil},
+	OpAppend:        {"APPEND", nil},
+	OpAppendSpread:  {"APPEND_SPREAD", nil},
+	OpNewObject:     {"NEW_OBJECT", nil},
+	OpSetProperty:   {"SET_PROPERTY", []operandKind{u16}},
+	OpSpreadObject:  {"SPREAD_OBJECT", nil},
+	OpTemplate:      {"TEMPLATE", []operandKind{u16}},
+}
+
+func readU16(code []byte, offset int) int {
+	return int(binary.BigEndian.Uint16(code[offset:]))
+}
+
+func readU32(code []byte, offset int) int {
+	return int(binary.BigEndian.Uint32(code[offset:]))
+}
+
+// Disassemble returns a readable listing of the function's code followed by
+// the listings of the functions it defines.
+func Disassemble(fn *Function) string {
+	var builder strings.Builder
+	disassemble(fn, &builder)
+	return builder.String()
+}
+
+func disassemble(fn *Function, builder *strings.Builder) {
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	fmt.Fprintf(builder, "== %s ==\n", name)
+	var nested []*Function
+	for offset := 0; offset < len(fn.Code); {
+		op := Opcode(fn.Code[offset])
+		info := opcodeInfos[op]
+		fmt.Fprintf(builder, "%04d %-16s", offset, info.name)
+		offset++
+		for _, kind := range info.operands {
+			var operand int
+			if kind == u16 {
+				operand = readU16(fn.Code, offset)
+			} else {
+				operand = readU32(fn.Code, offset)
+			}
+			offset += int(kind)
+			fmt.Fprintf(builder, " %d", operand)
+		}
+		switch op {
+		case OpConstant, OpGetName, OpSetName, OpUpdateName, OpGetMember, OpSetMember, OpSetProperty, OpGetMethod, OpResolveFunc, OpBinary, OpDefineFunc, OpDefineGlobal:
+			constant := fn.Constants[readU16(fn.Code, offset-operandSize(info))]
+			if proto, ok := constant.(*Function); ok {
+				nested = append(nested, proto)
+				fmt.Fprintf(builder, "\t; fn %s", proto.Name)
+			} else if operator, ok := constant.(token.Token); ok {
+				fmt.Fprintf(builder, "\t; %s", operator.Literal)
+			} else {
+				fmt.Fprintf(builder, "\t; %v", constant)
+			}
+		}
+		builder.WriteString("\n")
+	}
+	for _, proto := range nested {
+		disassemble(proto, builder)
+	}
+}
+
+func operandSize(info opcodeInfo) int {
+	size := 0
+	for _, kind := range info.operands {
+		size += int(kind)
+	}
+	return size
+}
@@ -0,0 +1,492 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	environment "theparadance.com/quan-lang/src/env"
+	"theparadance.com/quan-lang/src/expression"
+	"theparadance.com/quan-lang/src/helper"
+	interpreter "theparadance.com/quan-lang/src/intepreter"
+	"theparadance.com/quan-lang/src/object"
+	"theparadance.com/quan-lang/src/token"
+)
+
+// unset marks a local slot that has not been assigned yet. Reading it falls
+// back to the dynamic lookup the interpreter would do.
+type unset struct{}
+
+var undefined = &unset{}
+
+// methodRef is pushed by OpGetMethod in place of the callee when the
+// receiver is an array or a string, whose methods are built in.
+type methodRef struct {
+	name string
+}
+
+type frame struct {
+	fn    *Function
+	ip    int
+	base  int // stack index of the first local
+	drop  int // values below base to remove on return (callee, receiver)
+	funcs []*Function
+}
+
+// VM runs a compiled program. A VM is not safe for concurrent use; create
+// one per execution.
+type VM struct {
+	program *Program
+	env     *environment.Env // the program scope
+	stack   []interface{}
+	frames  []frame
+	globals map[string]*Function // named functions defined at the top level
+	limits  *environment.Limits  // nil when the execution is unlimited
+}
+
+func New(program *Program, env *environment.Env) *VM {
+	return &VM{
+		program: program,
+		env:     env,
+		stack:   make([]interface{}, 0, 256),
+		frames:  make([]frame, 0, 64),
+		globals: make(map[string]*Function),
+		limits:  env.Limits,
+	}
+}
+
+// Run executes the program. Variables and functions defined at the top
+// level end up in the program scope, as with the interpreter.
+func (vm *VM) Run() {
+	vm.frames = append(vm.frames, frame{fn: vm.program.Main})
+	vm.run(0)
+	vm.pop()
+}
+
+func (vm *VM) push(val interface{}) {
+	vm.stack = append(vm.stack, val)
+}
+
+func (vm *VM) pop() interface{} {
+	val := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return val
+}
+
+// run executes instructions until the number of frames drops to depth,
+// leaving the returned value on the stack.
+func (vm *VM) run(depth int) {
+	fr := &vm.frames[len(vm.frames)-1]
+	code := fr.fn.Code
+	constants := fr.fn.Constants
+
+	for {
+		if vm.limits != nil {
+			vm.limits.Step()
+		}
+		op := Opcode(code[fr.ip])
+		fr.ip++
+		switch op {
+		case OpConstant:
+			vm.push(constants[readU16(code, fr.ip)])
+			fr.ip += 2
+		case OpNull:
+			vm.push(interpreter.Null)
+		case OpPop:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case OpDup:
+			vm.push(vm.stack[len(vm.stack)-1])
+		case OpGetLocal:
+			slot := readU16(code, fr.ip)
+			fr.ip += 2
+			val := vm.stack[fr.base+slot]
+			if val == undefined {
+				val = vm.lookup(fr.fn.VarNames[slot])
+			}
+			vm.push(val)
+		case OpSetLocal:
+			vm.stack[fr.base+readU16(code, fr.ip)] = vm.pop()
+			fr.ip += 2
+		case OpUpdateLocal:
+			slot := readU16(code, fr.ip)
+			fr.ip += 2
+			val := vm.pop()
+			if vm.stack[fr.base+slot] != undefined || !vm.update(fr.fn.VarNames[slot], val) {
+				vm.stack[fr.base+slot] = val
+			}
+		case OpGetName:
+			vm.push(vm.lookup(constants[readU16(code, fr.ip)].(string)))
+			fr.ip += 2
+		case OpSetName:
+			vm.env.SetVar(constants[readU16(code, fr.ip)].(string), vm.pop())
+			fr.ip += 2
+		case OpUpdateName:
+			name := constants[readU16(code, fr.ip)].(string)
+			fr.ip += 2
+			val := vm.pop()
+			if !vm.update(name, val) {
+				vm.env.SetVar(name, val)
+			}
+		case OpDefineFunc:
+			proto := constants[readU16(code, fr.ip)].(*Function)
+			fr.funcs[readU16(code, fr.ip+2)] = proto
+			fr.ip += 4
+		case OpDefineGlobal:
+			proto := constants[readU16(code, fr.ip)].(*Function)
+			fr.ip += 2
+			vm.globals[proto.Name] = proto
+			vm.env.Funcs[proto.Name] = proto.Def
+		case OpBinary:
+			operator := constants[readU16(code, fr.ip)].(token.Token)
+			fr.ip += 2
+			right := vm.pop()
+			left := vm.stack[len(vm.stack)-1]
+			result := binaryOp(operator, left, right)
+			vm.limits.Alloc(result)
+			vm.stack[len(vm.stack)-1] = result
+		case OpJump:
+			fr.ip = readU32(code, fr.ip)
+		case OpJumpIfFalse:
+			if interpreter.IsTruthy(vm.pop()) {
+				fr.ip += 4
+			} else {
+				fr.ip = readU32(code, fr.ip)
+			}
+		case OpJumpIfNotNull:
+			if interpreter.IsNull(vm.stack[len(vm.stack)-1]) {
+				vm.stack = vm.stack[:len(vm.stack)-1]
+				fr.ip += 4
+			} else {
+				fr.ip = readU32(code, fr.ip)
+			}
+		case OpShortIfNull:
+			if interpreter.IsNull(vm.stack[len(vm.stack)-1]) {
+				vm.stack[len(vm.stack)-1] = interpreter.Null
+				fr.ip = readU32(code, fr.ip)
+			} else {
+				fr.ip += 4
+			}
+		case OpGetMember:
+			name := constants[readU16(code, fr.ip)].(string)
+			fr.ip += 2
+			vm.stack[len(vm.stack)-1] = interpreter.MemberValue(vm.stack[len(vm.stack)-1], name)
+		case OpGetIndex:
+			index := vm.pop()
+			vm.stack[len(vm.stack)-1] = interpreter.IndexValue(vm.stack[len(vm.stack)-1], index)
+		case OpSetMember:
+			name := constants[readU16(code, fr.ip)].(string)
+			fr.ip += 2
+			obj := vm.pop()
+			interpreter.AllocProperty(vm.limits, obj, name)
+			interpreter.SetMember(obj, name, vm.pop())
+		case OpSetIndex:
+			index := vm.pop()
+			container := vm.pop()
+			interpreter.AllocProperty(vm.limits, container, index)
+			interpreter.SetIndex(container, index, vm.pop())
+		case OpGetMethod:
+			name := constants[readU16(code, fr.ip)].(string)
+			flags := readU16(code, fr.ip+2)
+			target := readU32(code, fr.ip+4)
+			fr.ip += 8
+			receiver := vm.stack[len(vm.stack)-1]
+			switch receiver.(type) {
+			case *object.Array, string:
+				vm.push(&methodRef{name: name})
+				continue
+			}
+			callee := interpreter.MemberValue(receiver, name)
+			if flags&optionalCall != 0 && interpreter.IsNull(callee) {
+				vm.stack[len(vm.stack)-1] = interpreter.Null
+				fr.ip = target
+				continue
+			}
+			vm.push(callee)
+		case OpResolveFunc:
+			name := constants[readU16(code, fr.ip)].(string)
+			argc := readU16(code, fr.ip+2)
+			fr.ip += 4
+			vm.push(vm.resolveFunc(name, argc))
+		case OpCall:
+			argc := readU16(code, fr.ip)
+			fr.ip += 2
+			callee := vm.stack[len(vm.stack)-argc-1]
+			if fn, ok := callee.(*Function); ok {
+				if argc != fn.NumParams {
+					panic(fmt.Sprintf("Function expects %d args, got %d", fn.NumParams, argc))
+				}
+				vm.enter(fn, 1)
+				fr = &vm.frames[len(vm.frames)-1]
+				code, constants = fr.fn.Code, fr.fn.Constants
+				continue
+			}
+			args := vm.popArgs(argc)
+			vm.stack[len(vm.stack)-1] = vm.callHost(callee, args)
+		case OpCallMethod:
+			argc := readU16(code, fr.ip)
+			fr.ip += 2
+			callee := vm.stack[len(vm.stack)-argc-1]
+			if ref, ok := callee.(*methodRef); ok {
+				args := vm.popArgs(argc)
+				vm.stack = vm.stack[:len(vm.stack)-1]
+				receiver := vm.stack[len(vm.stack)-1]
+				if arr, ok := receiver.(*object.Array); ok {
+					result := interpreter.CallArrayMethod(arr, ref.name, args, vm.call, vm.limits)
+					// callbacks may have grown vm.frames
+					fr = &vm.frames[len(vm.frames)-1]
+					vm.stack[len(vm.stack)-1] = result
+					continue
+				}
+				vm.stack[len(vm.stack)-1] = interpreter.CallStringMethod(receiver.(string), ref.name, args, vm.limits)
+			} else if fn, ok := callee.(*Function); ok {
+				if argc != fn.NumParams {
+					panic(fmt.Sprintf("Function expects %d args, got %d", fn.NumParams, argc))
+				}
+				vm.enter(fn, 2)
+				fr = &vm.frames[len(vm.frames)-1]
+				code, constants = fr.fn.Code, fr.fn.Constants
+				continue
+			} else {
+				args := vm.popArgs(argc)
+				vm.stack = vm.stack[:len(vm.stack)-1]
+				vm.stack[len(vm.stack)-1] = vm.callHost(callee, args)
+			}
+		case OpReturn:
+			if vm.limits != nil && len(vm.frames) > 1 {
+				vm.limits.Leave()
+			}
+			result := vm.pop()
+			vm.stack = vm.stack[:fr.base-fr.drop]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(result)
+			if len(vm.frames) == depth {
+				return
+			}
+			fr = &vm.frames[len(vm.frames)-1]
+			code, constants = fr.fn.Code, fr.fn.Constants
+		case OpArray:
+			count := readU16(code, fr.ip)
+			fr.ip += 2
+			arr := object.NewArray(nil)
+			if count > 0 {
+				arr.Items = make([]interface{}, count)
+				copy(arr.Items, vm.stack[len(vm.stack)-count:])
+				vm.stack = vm.stack[:len(vm.stack)-count]
+			}
+			vm.limits.Alloc(arr)
+			vm.push(arr)
+		case OpNewArray:
+			arr := object.NewArray(nil)
+			vm.limits.Alloc(arr)
+			vm.push(arr)
+		case OpAppend:
+			val := vm.pop()
+			vm.limits.AllocBytes(object.ElementSize)
+			arr := vm.stack[len(vm.stack)-1].(*object.Array)
+			arr.Items = append(arr.Items, val)
+		case OpAppendSpread:
+			items := interpreter.SpreadArray(vm.pop())
+			vm.limits.AllocBytes(object.ElementSize * int64(len(items)))
+			arr := vm.stack[len(vm.stack)-1].(*object.Array)
+			arr.Items = append(arr.Items, items...)
+		case OpNewObject:
+			obj := object.NewObject()
+			vm.limits.Alloc(obj)
+			vm.push(obj)
+		case OpSetProperty:
+			name := constants[readU16(code, fr.ip)].(string)
+			fr.ip += 2
+			val := vm.pop()
+			obj := vm.stack[len(vm.stack)-1].(*object.Object)
+			interpreter.AllocProperty(vm.limits, obj, name)
+			obj.SetProperty(name, val)
+		case OpSpreadObject:
+			val := vm.pop()
+			obj := vm.stack[len(vm.stack)-1].(*object.Object)
+			before := obj.Len()
+			interpreter.SpreadObject(obj, val)
+			for _, key := range obj.Keys()[before:] {
+				vm.limits.AllocBytes(object.PropertySize(key))
+			}
+		case OpTemplate:
+			count := readU16(code, fr.ip)
+			fr.ip += 2
+			var builder strings.Builder
+			for _, part := range vm.stack[len(vm.stack)-count:] {
+				builder.WriteString(fmt.Sprint(part))
+			}
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			result := builder.String()
+			vm.limits.Alloc(result)
+			vm.push(result)
+		default:
+			panic(fmt.Sprintf("Unknown opcode %d", op))
+		}
+	}
+}
+
+// enter pushes a frame for fn, whose arguments are on top of the stack.
+func (vm *VM) enter(fn *Function, drop int) {
+	if vm.limits != nil {
+		vm.limits.Enter()
+	}
+	base := len(vm.stack) - fn.NumParams
+	for i := fn.NumParams; i < fn.NumVars; i++ {
+		vm.push(undefined)
+	}
+	fr := frame{fn: fn, base: base, drop: drop}
+	if fn.NumFuncs > 0 {
+		fr.funcs = make([]*Function, fn.NumFuncs)
+	}
+	vm.frames = append(vm.frames, fr)
+}
+
+// call invokes a function value from Go, e.g. an array method callback.
+// Like the interpreter's callbacks, extra arguments are dropped and missing
+// ones are null.
+func (vm *VM) call(callee interface{}, args ...interface{}) interface{} {
+	fn, ok := callee.(*Function)
+	if !ok {
+		return interpreter.CallCallback(callee, vm.env, args...)
+	}
+	vm.push(fn)
+	for i := 0; i < fn.NumParams; i++ {
+		if i < len(args) {
+			vm.push(args[i])
+		} else {
+			vm.push(interpreter.Null)
+		}
+	}
+	depth := len(vm.frames)
+	vm.enter(fn, 1)
+	vm.run(depth)
+	return vm.pop()
+}
+
+// callHost calls a function that does not run on the VM: a builtin, a
+// module closure or a function definition from the host environment.
+func (vm *VM) callHost(callee interface{}, args []interface{}) interface{} {
+	return interpreter.CallValue(callee, args, vm.env)
+}
+
+func (vm *VM) popArgs(argc int) []interface{} {
+	args := make([]interface{}, argc)
+	copy(args, vm.stack[len(vm.stack)-argc:])
+	vm.stack = vm.stack[:len(vm.stack)-argc]
+	return args
+}
+
+// lookup finds a name the way the interpreter resolves a variable: in the
+// calling frames and the program scope, then among named functions, then
+// builtins.
+func (vm *VM) lookup(name string) interface{} {
+	if val, ok := vm.lookupVar(name); ok {
+		return val
+	}
+	if fn, ok := vm.lookupFunc(name); ok {
+		return fn
+	}
+	if builtin, ok := vm.env.GetBuiltin(name); ok {
+		return builtin
+	}
+	panic("Undefined variable: " + name)
+}
+
+func (vm *VM) lookupVar(name string) (interface{}, bool) {
+	for i := len(vm.frames) - 1; i > 0; i-- {
+		fr := &vm.frames[i]
+		if slot, ok := fr.fn.VarSlots[name]; ok {
+			if val := vm.stack[fr.base+slot]; val != undefined {
+				return val, true
+			}
+		}
+	}
+	return vm.env.GetVar(name)
+}
+
+func (vm *VM) lookupFunc(name string) (interface{}, bool) {
+	if vm.program.NestedFuncs {
+		for i := len(vm.frames) - 1; i > 0; i-- {
+			fr := &vm.frames[i]
+			if slot, ok := fr.fn.FuncSlots[name]; ok && fr.funcs[slot] != nil {
+				return fr.funcs[slot], true
+			}
+		}
+	}
+	if fn, ok := vm.globals[name]; ok {
+		return fn, true
+	}
+	if def, ok := vm.env.GetFunc(name); ok {
+		return def, true
+	}
+	return nil, false
+}
+
+// update assigns an existing variable in the frame or scope that defines
+// it, and reports whether one was found.
+func (vm *VM) update(name string, val interface{}) bool {
+	for i := len(vm.frames) - 1; i > 0; i-- {
+		fr := &vm.frames[i]
+		if slot, ok := fr.fn.VarSlots[name]; ok && vm.stack[fr.base+slot] != undefined {
+			vm.stack[fr.base+slot] = val
+			return true
+		}
+	}
+	return vm.env.UpdateVar(name, val)
+}
+
+// resolveFunc finds the function called by `name(...)`: a named function,
+// then a builtin, then a variable holding a function.
+func (vm *VM) resolveFunc(name string, argc int) interface{} {
+	if fn, ok := vm.lookupFunc(name); ok {
+		if params := paramCount(fn); params != argc {
+			panic(fmt.Sprintf("Function %s expects %d args, got %d", name, params, argc))
+		}
+		return fn
+	}
+	if builtin, ok := vm.env.GetBuiltin(name); ok {
+		return builtin
+	}
+	if val, ok := vm.lookupVar(name); ok {
+		switch fn := val.(type) {
+		case *Function, expression.FuncDef:
+			if params := paramCount(fn); params != argc {
+				panic(fmt.Sprintf("Function expects %d args, got %d", params, argc))
+			}
+			return fn
+		case environment.BuiltinFunc, *environment.Closure:
+			return fn
+		}
+		panic("Variable is not a function")
+	}
+	panic("Function not found: " + name)
+}
+
+func paramCount(fn interface{}) int {
+	switch f := fn.(type) {
+	case *Function:
+		return f.NumParams
+	case expression.FuncDef:
+		return len(f.Params)
+	}
+	return 0
+}
+
+// binaryOp is BinaryOp with a fast path for arithmetic and comparisons on
+// float64, the type of every number literal.
+func binaryOp(operator token.Token, left interface{}, right interface{}) interface{} {
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			switch operator.Type {
+			case token.TokenPlus:
+				return l + r
+			case token.TokenMinus:
+				return l - r
+			case token.TokenStar:
+				return l * r
+			case token.TokenLT, token.TokenLE, token.TokenGT, token.TokenGE, token.TokenEqual, token.TokenNE:
+				return helper.CompareFloats(l, r, operator.Type)
+			}
+		}
+	}
+	return interpreter.BinaryOp(operator, left, right)
+}
@@ -0,0 +1,103 @@
+// Command engine-check runs every script of the shared corpus on the
+// tree-walking interpreter, on the bytecode VM and on the interpreter with
+// the optimizer enabled, and reports any script whose console output
+// differs between them.
+//
+//	go run ./test/engine-check -corpus test/corpus
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	lang "theparadance.com/quan-lang/quan-lang"
+	builtinfunc "theparadance.com/quan-lang/src/builtin-func"
+	debuglevel "theparadance.com/quan-lang/src/debug/debug-level"
+	"theparadance.com/quan-lang/src/env"
+	lexer "theparadance.com/quan-lang/src/lexer"
+	parser "theparadance.com/quan-lang/src/paraser"
+	systemconsole "theparadance.com/quan-lang/src/system-console"
+	"theparadance.com/quan-lang/src/vm"
+)
+
+func main() {
+	corpus := flag.String("corpus", "test/corpus", "Directory of .qlang scripts")
+	repeat := flag.Int("n", 1, "Runs per engine, for timing")
+	flag.Parse()
+
+	files, err := filepath.Glob(filepath.Join(*corpus, "*.qlang"))
+	if err != nil || len(files) == 0 {
+		fmt.Println("no scripts found in", *corpus)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+
+		note := ""
+		p := parser.Parser{Tokens: lexer.Lex(string(source))}
+		if _, err := vm.Compile(p.Parse()); err != nil {
+			note = " (interpreter fallback: " + err.Error() + ")"
+		}
+
+		treeOut, treeTime := run(string(source), lang.TREE_WALK_ENGINE, false, *repeat)
+		vmOut, vmTime := run(string(source), lang.BYTECODE_ENGINE, false, *repeat)
+		optOut, optTime := run(string(source), lang.TREE_WALK_ENGINE, true, *repeat)
+		status := "ok  "
+		if treeOut != vmOut || treeOut != optOut {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %-28s tree %10v  vm %10v  optimized %10v%s\n", status, filepath.Base(file), treeTime, vmTime, optTime, note)
+		if treeOut != vmOut {
+			fmt.Printf("--- interpreter\n%s--- vm\n%s", treeOut, vmOut)
+		}
+		if treeOut != optOut {
+			fmt.Printf("--- interpreter\n%s--- optimized\n%s", treeOut, optOut)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d scripts differ\n", failed, len(files))
+		os.Exit(1)
+	}
+}
+
+func run(source string, engine string, optimize bool, repeat int) (output string, elapsed time.Duration) {
+	for i := 0; i < repeat; i++ {
+		output = runOnce(source, engine, optimize, &elapsed)
+	}
+	return output, elapsed / time.Duration(repeat)
+}
+
+func runOnce(source string, engine string, optimize bool, elapsed *time.Duration) (output string) {
+	console := systemconsole.NewVirtualSystemConsole()
+	debugLevels := []debuglevel.DebugLevel{}
+	option := lang.NewExecuationOption(console, lang.RELEASE_MODE, &debugLevels)
+	option.Engine = engine
+	option.Optimize = optimize
+	e := &env.Env{
+		Vars: map[string]interface{}{
+			"host": map[string]interface{}{"name": "q", "age": 3},
+		},
+		Builtin: builtinfunc.BuildInFuncs(console),
+	}
+
+	start := time.Now()
+	defer func() {
+		*elapsed += time.Since(start)
+		if r := recover(); r != nil {
+			output = console.String() + fmt.Sprintf("panic: %v\n", r)
+			return
+		}
+		output = console.String()
+	}()
+	lang.Execuate(source, e, option)
+	return
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "theparadance.com/quan-lang/src/expression"
+import (
+	"strings"
+
+	"theparadance.com/quan-lang/src/expression"
+)
 
 func printIndent(index int) {
 	for i := 0; i < index; i++ {
@@ -43,6 +47,8 @@ func PrintExpression(expr expression.Expr, indent int) {
 		PrintExpression(e.FalseValue, indent+4)
 	case expression.VarExpr:
 		println("[VariableExpr]:", e.Name)
+	case expression.LocalExpr:
+		println("[LocalExpr]:", e.Name, "depth:", e.Depth, "slot:", e.Slot)
 	case expression.IfExpr:
 		println("If Condition:", e.Condition)
 		println("Then Branches:", len(e.Then), "Else Branches:", len(e.Else))
@@ -68,10 +74,10 @@ func PrintExpression(expr expression.Expr, indent int) {
 		println("[BooleanExpr]:", e.Value)
 	case expression.ObjectExpr:
 		println("[ObjectExpr]:", len(e.Pairs))
-		for key, value := range e.Pairs {
+		for _, pair := range e.Pairs {
 			printIndent(indent + 4)
-			println("Key:", key, "Value:", value)
-			PrintExpression(value, indent+8)
+			println("Key:", pair.Key, "Value:", pair.Value)
+			PrintExpression(pair.Value, indent+8)
 		}
 	case expression.MemberExpr:
 		println("[MemberExpr]:", e.Object, "Property:", e.Property)
@@ -96,5 +102,68 @@ func PrintExpression(expr expression.Expr, indent int) {
 		println("[IndexExpr]:", e.Array, "Index:", e.Index)
 		PrintExpression(e.Array, indent+4)
 		PrintExpression(e.Index, indent+4)
+	case expression.ImportExpr:
+		println("[ImportExpr]:", e.Path)
+		for _, name := range e.Names {
+			printIndent(indent + 4)
+			println("Name:", name.Name, "As:", name.Alias)
+		}
+	case expression.ExportExpr:
+		println("[ExportExpr]:", strings.Join(e.Names, ", "))
+		if e.Decl != nil {
+			PrintExpression(e.Decl, indent+4)
+		}
+	case expression.MatchExpr:
+		println("[MatchExpr]: Arms:", len(e.Arms))
+		PrintExpression(e.Subject, indent+4)
+		for _, arm := range e.Arms {
+			PrintExpression(arm.Pattern, indent+4)
+			if arm.Guard != nil {
+				PrintExpression(arm.Guard, indent+8)
+			}
+			for _, bodyExpr := range arm.Body {
+				PrintExpression(bodyExpr, indent+8)
+			}
+		}
+	case expression.WildcardPattern:
+		println("[WildcardPattern]")
+	case expression.BindingPattern:
+		println("[BindingPattern]:", e.Name)
+	case expression.TypePattern:
+		println("[TypePattern]:", e.Type)
+	case expression.RangePattern:
+		println("[RangePattern]:")
+		PrintExpression(e.Low, indent+4)
+		PrintExpression(e.High, indent+4)
+	case expression.ShapePattern:
+		println("[ShapePattern]:", len(e.Properties))
+		for _, prop := range e.Properties {
+			printIndent(indent + 4)
+			println("Key:", prop.Key)
+			if prop.Pattern != nil {
+				PrintExpression(prop.Pattern, indent+8)
+			}
+		}
+	case expression.SpreadExpr:
+		println("[SpreadExpr]:")
+		PrintExpression(e.Value, indent+4)
+	case expression.ObjectPattern:
+		println("[ObjectPattern]:", len(e.Properties))
+		for _, prop := range e.Properties {
+			printIndent(indent + 4)
+			println("Key:", prop.Key)
+			PrintExpression(prop.Target, indent+8)
+		}
+		if e.Rest != nil {
+			PrintExpression(e.Rest, indent+4)
+		}
+	case expression.ArrayPattern:
+		println("[ArrayPattern]:", len(e.Elements))
+		for _, element := range e.Elements {
+			PrintExpression(element.Target, indent+4)
+		}
+		if e.Rest != nil {
+			PrintExpression(e.Rest, indent+4)
+		}
 	}
 }